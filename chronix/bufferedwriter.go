@@ -0,0 +1,311 @@
+package chronix
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pointSize is the estimated size of a buffered point in bytes.
+const pointSize = 16
+
+// Defaults for the limits of a BufferedWriter.
+const (
+	DefaultMaxChunkPoints = 1000
+	DefaultMaxChunkAge    = time.Minute
+)
+
+// ErrWriterClosed is returned when writing to a closed BufferedWriter.
+var ErrWriterClosed = errors.New("buffered writer is closed")
+
+// BufferedWriterOptions configures a BufferedWriter.
+type BufferedWriterOptions struct {
+	// MaxChunkPoints is the number of points after which a chunk is cut.
+	// If it is 0, DefaultMaxChunkPoints is used.
+	MaxChunkPoints int
+	// MaxChunkBytes is the estimated uncompressed size in bytes (16 bytes per point)
+	// after which a chunk is cut. If it is 0, chunks are not cut by size.
+	MaxChunkBytes int
+	// MaxChunkAge is the time after which a chunk is cut, measured from the first point
+	// added to it. If it is 0, DefaultMaxChunkAge is used.
+	MaxChunkAge time.Duration
+	// MaxBufferedPoints limits the number of points that are buffered or being stored.
+	// When it is reached, all buffered points are stored and writes block until they
+	// have been stored. If it is 0, the number of buffered points is not limited.
+	MaxBufferedPoints int
+	// CommitWithin is passed to the client when storing chunks.
+	CommitWithin time.Duration
+	// ReservedAttributePrefix renames reserved attributes of chunks the client
+	// rejects by prefixing them, see StoreEach. If it is empty, such chunks are
+	// reported through OnError.
+	ReservedAttributePrefix string
+	// OnError is called with errors of background flushes. The chunks of a failed
+	// flush are dropped. Chunks the client rejects with an *InvalidSeriesError are
+	// reported one by one, also on Flush and Close, and the other chunks are stored.
+	// It must not block.
+	OnError func(error)
+}
+
+// A BufferedWriter accumulates points per series and stores them as chunks
+// through a Client in the background. Series are identified by their name,
+// type and attributes. Points have to be written in timestamp order per series.
+type BufferedWriter struct {
+	client Client
+	opts   BufferedWriterOptions
+
+	mtx     sync.Mutex
+	pending map[string]*bufferedSeries
+	ready   []*TimeSeries
+	closed  bool
+
+	// buffered is the number of points that are buffered or being stored.
+	buffered int
+	// space is closed and replaced when stored points have been released.
+	space chan struct{}
+
+	// storeMtx serializes the storing of chunks.
+	storeMtx sync.Mutex
+
+	kick chan struct{}
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+type bufferedSeries struct {
+	series  *TimeSeries
+	created time.Time
+}
+
+// NewBufferedWriter creates a new BufferedWriter on top of the client and
+// starts flushing in the background.
+func NewBufferedWriter(c Client, opts BufferedWriterOptions) *BufferedWriter {
+	if opts.MaxChunkPoints <= 0 {
+		opts.MaxChunkPoints = DefaultMaxChunkPoints
+	}
+	if opts.MaxChunkAge <= 0 {
+		opts.MaxChunkAge = DefaultMaxChunkAge
+	}
+	w := &BufferedWriter{
+		client:  c,
+		opts:    opts,
+		pending: map[string]*bufferedSeries{},
+		space:   make(chan struct{}),
+		kick:    make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+// Write adds the points of the series to the buffer. It blocks while the
+// maximum number of buffered points is reached.
+func (w *BufferedWriter) Write(ts *TimeSeries) error {
+	return w.WriteContext(context.Background(), ts)
+}
+
+// WriteContext is like Write but returns the error of ctx if it is done before
+// the points could be added to the buffer. Series without a name are rejected
+// with an *InvalidSeriesError.
+func (w *BufferedWriter) WriteContext(ctx context.Context, ts *TimeSeries) error {
+	if ts.Name == "" {
+		return &InvalidSeriesError{Name: ts.Name, Attributes: ts.Attributes, Err: ErrEmptyName}
+	}
+
+	w.mtx.Lock()
+	for w.opts.MaxBufferedPoints > 0 && w.buffered >= w.opts.MaxBufferedPoints && !w.closed {
+		space := w.space
+		w.mtx.Unlock()
+		select {
+		case <-space:
+		case <-w.quit:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		w.mtx.Lock()
+	}
+	if w.closed {
+		w.mtx.Unlock()
+		return ErrWriterClosed
+	}
+
+	key := seriesKey(ts)
+	cut := false
+	for _, p := range ts.Points {
+		b, ok := w.pending[key]
+		if !ok {
+			b = &bufferedSeries{
+				series: &TimeSeries{
+					Name:         ts.Name,
+					Type:         ts.Type,
+					Attributes:   copyAttributes(ts.Attributes),
+					DDCThreshold: ts.DDCThreshold,
+				},
+				created: time.Now(),
+			}
+			w.pending[key] = b
+		}
+		b.series.Points = append(b.series.Points, p)
+
+		if w.isFull(b.series) {
+			w.ready = append(w.ready, b.series)
+			delete(w.pending, key)
+			cut = true
+		}
+	}
+	w.buffered += len(ts.Points)
+	if w.opts.MaxBufferedPoints > 0 && w.buffered >= w.opts.MaxBufferedPoints {
+		w.cut(func(*bufferedSeries) bool { return true })
+		cut = true
+	}
+	w.mtx.Unlock()
+
+	if cut {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush stores all buffered points, including the ones of chunks that are not full yet.
+func (w *BufferedWriter) Flush() error {
+	w.mtx.Lock()
+	w.cut(func(*bufferedSeries) bool { return true })
+	w.mtx.Unlock()
+	return w.store()
+}
+
+// Close stops the background flushing and stores all buffered points.
+// Writing to a closed BufferedWriter returns ErrWriterClosed.
+func (w *BufferedWriter) Close() error {
+	w.mtx.Lock()
+	if w.closed {
+		w.mtx.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mtx.Unlock()
+
+	close(w.quit)
+	w.wg.Wait()
+	return w.Flush()
+}
+
+func (w *BufferedWriter) run() {
+	defer w.wg.Done()
+
+	interval := w.opts.MaxChunkAge / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.kick:
+		case now := <-ticker.C:
+			w.mtx.Lock()
+			w.cut(func(b *bufferedSeries) bool {
+				return now.Sub(b.created) >= w.opts.MaxChunkAge
+			})
+			w.mtx.Unlock()
+		case <-w.quit:
+			return
+		}
+
+		if err := w.store(); err != nil && w.opts.OnError != nil {
+			w.opts.OnError(err)
+		}
+	}
+}
+
+func (w *BufferedWriter) isFull(ts *TimeSeries) bool {
+	if len(ts.Points) >= w.opts.MaxChunkPoints {
+		return true
+	}
+	return w.opts.MaxChunkBytes > 0 && len(ts.Points)*pointSize >= w.opts.MaxChunkBytes
+}
+
+// cut moves the pending series selected by the filter to the ready chunks.
+// The caller has to hold w.mtx.
+func (w *BufferedWriter) cut(filter func(*bufferedSeries) bool) {
+	for key, b := range w.pending {
+		if filter(b) {
+			w.ready = append(w.ready, b.series)
+			delete(w.pending, key)
+		}
+	}
+}
+
+// store stores the ready chunks.
+func (w *BufferedWriter) store() error {
+	w.storeMtx.Lock()
+	defer w.storeMtx.Unlock()
+
+	w.mtx.Lock()
+	ready := w.ready
+	w.ready = nil
+	w.mtx.Unlock()
+
+	if len(ready) == 0 {
+		return nil
+	}
+	err := w.storeChunks(ready)
+
+	// Failed chunks are dropped, so their points are released as well.
+	points := 0
+	for _, ts := range ready {
+		points += len(ts.Points)
+	}
+	w.mtx.Lock()
+	w.buffered -= points
+	close(w.space)
+	w.space = make(chan struct{})
+	w.mtx.Unlock()
+	return err
+}
+
+// storeChunks stores the chunks. Invalid chunks are reported through OnError
+// and the others are stored without them.
+func (w *BufferedWriter) storeChunks(chunks []*TimeSeries) error {
+	invalid, err := StoreEach(context.Background(), w.client, chunks, false, w.opts.CommitWithin, w.opts.ReservedAttributePrefix)
+	if w.opts.OnError != nil {
+		for _, e := range invalid {
+			w.opts.OnError(e)
+		}
+	}
+	return err
+}
+
+// seriesKey returns the identity of a series made of its name, type and attributes.
+func seriesKey(ts *TimeSeries) string {
+	names := make([]string, 0, len(ts.Attributes))
+	for k := range ts.Attributes {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, 2+2*len(names))
+	parts = append(parts, ts.Name, ts.Type)
+	for _, k := range names {
+		parts = append(parts, k, ts.Attributes[k])
+	}
+	return strings.Join(parts, "\xff")
+}
+
+func copyAttributes(attributes map[string]string) map[string]string {
+	if attributes == nil {
+		return nil
+	}
+	c := make(map[string]string, len(attributes))
+	for k, v := range attributes {
+		c[k] = v
+	}
+	return c
+}
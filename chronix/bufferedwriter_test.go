@@ -0,0 +1,217 @@
+package chronix
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func pointsFrom(start, count int) []Point {
+	points := make([]Point, 0, count)
+	for i := start; i < start+count; i++ {
+		points = append(points, Point{Timestamp: int64(i), Value: float64(i)})
+	}
+	return points
+}
+
+func TestBufferedWriterCutsByPoints(t *testing.T) {
+	// given:
+	storage := &recordingStorage{}
+	w := NewBufferedWriter(New(storage), BufferedWriterOptions{MaxChunkPoints: 10, MaxChunkAge: time.Hour})
+	defer w.Close()
+
+	// when:
+	for i := 0; i < 25; i++ {
+		if err := w.Write(&TimeSeries{Name: "test", Type: "metric", Points: pointsFrom(i, 1)}); err != nil {
+			t.Fatal("Error writing:", err)
+		}
+	}
+
+	// then:
+	deadline := time.Now().Add(time.Second)
+	for len(storage.documents()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	docs := storage.documents()
+	if len(docs) != 2 {
+		t.Fatalf("Expected 2 full chunks to be stored, got %d", len(docs))
+	}
+	if docs[0]["start"] != int64(0) || docs[0]["end"] != int64(9) || docs[1]["start"] != int64(10) {
+		t.Errorf("Unexpected chunks: %v", docs)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+	if docs = storage.documents(); len(docs) != 3 || docs[2]["end"] != int64(24) {
+		t.Errorf("Expected the remaining points to be flushed, got %v", docs)
+	}
+}
+
+func TestBufferedWriterCutsByBytes(t *testing.T) {
+	storage := &recordingStorage{}
+	w := NewBufferedWriter(New(storage), BufferedWriterOptions{MaxChunkBytes: 5 * pointSize, MaxChunkAge: time.Hour})
+
+	if err := w.Write(&TimeSeries{Name: "test", Type: "metric", Points: pointsFrom(0, 12)}); err != nil {
+		t.Fatal("Error writing:", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("Error closing:", err)
+	}
+
+	docs := storage.documents()
+	if len(docs) != 3 {
+		t.Fatalf("Expected 3 chunks, got %d", len(docs))
+	}
+}
+
+func TestBufferedWriterSeparatesSeries(t *testing.T) {
+	storage := &recordingStorage{}
+	w := NewBufferedWriter(New(storage), BufferedWriterOptions{MaxChunkAge: time.Hour})
+
+	for _, host := range []string{"a", "b", "a"} {
+		ts := &TimeSeries{Name: "test", Type: "metric", Attributes: map[string]string{"host": host}, Points: pointsFrom(0, 1)}
+		if err := w.Write(ts); err != nil {
+			t.Fatal("Error writing:", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("Error closing:", err)
+	}
+
+	docs := storage.documents()
+	if len(docs) != 2 {
+		t.Fatalf("Expected one chunk per series, got %d", len(docs))
+	}
+}
+
+func TestBufferedWriterCutsByAge(t *testing.T) {
+	storage := &recordingStorage{}
+	w := NewBufferedWriter(New(storage), BufferedWriterOptions{MaxChunkAge: 20 * time.Millisecond})
+	defer w.Close()
+
+	if err := w.Write(&TimeSeries{Name: "test", Type: "metric", Points: pointsFrom(0, 3)}); err != nil {
+		t.Fatal("Error writing:", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(storage.documents()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(storage.documents()) != 1 {
+		t.Fatal("Expected the chunk to be stored after its maximum age")
+	}
+}
+
+func TestBufferedWriterReportsErrors(t *testing.T) {
+	storage := &recordingStorage{err: errors.New("storage down")}
+	errs := make(chan error, 1)
+	w := NewBufferedWriter(New(storage), BufferedWriterOptions{
+		MaxChunkPoints: 1,
+		MaxChunkAge:    time.Hour,
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	})
+	defer w.Close()
+
+	if err := w.Write(&TimeSeries{Name: "test", Type: "metric", Points: pointsFrom(0, 1)}); err != nil {
+		t.Fatal("Error writing:", err)
+	}
+
+	select {
+	case err := <-errs:
+		if err != storage.err {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the storage error to be reported")
+	}
+}
+
+func TestBufferedWriterStoresValidChunks(t *testing.T) {
+	storage := &noPostfixStorage{}
+	var errs []error
+	w := NewBufferedWriter(New(storage), BufferedWriterOptions{
+		MaxChunkAge: time.Hour,
+		OnError:     func(err error) { errs = append(errs, err) },
+	})
+
+	if err := w.Write(&TimeSeries{Type: "metric", Points: pointsFrom(0, 1)}); err == nil {
+		t.Error("Expected series without a name to be rejected")
+	}
+	series := []*TimeSeries{
+		{Name: "a", Type: "metric", Points: pointsFrom(0, 2)},
+		{Name: "b", Type: "metric", Attributes: map[string]string{"type": "gauge"}, Points: pointsFrom(0, 2)},
+		{Name: "c", Type: "metric", Points: pointsFrom(0, 2)},
+	}
+	for _, ts := range series {
+		if err := w.Write(ts); err != nil {
+			t.Fatal("Error writing:", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("Error closing writer:", err)
+	}
+
+	if len(storage.documents()) != 2 {
+		t.Errorf("Expected the valid chunks to be stored, got %v", storage.documents())
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	if e, ok := errs[0].(*InvalidSeriesError); !ok || e.Name != "b" {
+		t.Errorf("Expected an *InvalidSeriesError for series b, got %v", errs[0])
+	}
+}
+
+func TestBufferedWriterWriteAfterClose(t *testing.T) {
+	w := NewBufferedWriter(New(&recordingStorage{}), BufferedWriterOptions{})
+	if err := w.Close(); err != nil {
+		t.Fatal("Error closing:", err)
+	}
+	if err := w.Write(&TimeSeries{Name: "test", Points: pointsFrom(0, 1)}); err != ErrWriterClosed {
+		t.Fatal("Expected ErrWriterClosed, got", err)
+	}
+}
+
+// A StorageClient whose updates block until release is closed.
+type blockingStorage struct {
+	recordingStorage
+	release chan struct{}
+}
+
+func (s *blockingStorage) UpdateContext(ctx context.Context, data []map[string]interface{}, commit bool, commitWithin time.Duration) error {
+	<-s.release
+	return s.recordingStorage.UpdateContext(ctx, data, commit, commitWithin)
+}
+
+func TestBufferedWriterBlocksAtMaxBufferedPoints(t *testing.T) {
+	storage := &blockingStorage{release: make(chan struct{})}
+	w := NewBufferedWriter(New(storage), BufferedWriterOptions{MaxBufferedPoints: 5, MaxChunkAge: time.Hour})
+	defer w.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := w.Write(&TimeSeries{Name: "test", Type: "metric", Points: pointsFrom(i, 1)}); err != nil {
+			t.Fatal("Error writing:", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := w.WriteContext(ctx, &TimeSeries{Name: "test", Type: "metric", Points: pointsFrom(5, 1)}); err != context.DeadlineExceeded {
+		t.Fatal("Expected the write to block until the deadline, got", err)
+	}
+
+	close(storage.release)
+	if err := w.Write(&TimeSeries{Name: "test", Type: "metric", Points: pointsFrom(5, 1)}); err != nil {
+		t.Fatal("Error writing:", err)
+	}
+	if docs := storage.documents(); len(docs) != 1 || docs[0]["end"] != int64(4) {
+		t.Errorf("Expected the buffered points to be stored, got %v", docs)
+	}
+}
@@ -0,0 +1,62 @@
+package chronix
+
+import "time"
+
+// A ChunkingPolicy configures how the client splits the points of a series into
+// chunks when storing it. Each chunk is stored as a document of its own with its
+// own start, end and statistics. The limits can be combined; a new chunk is
+// started as soon as one of them is reached. The zero value stores every series
+// as a single chunk.
+//
+// The points of a series have to be sorted by their timestamps.
+type ChunkingPolicy struct {
+	// MaxPoints is the maximum number of points of a chunk.
+	MaxPoints int
+	// MaxSpan is the maximum time between the first and the last point of a chunk.
+	MaxSpan time.Duration
+	// Window aligns the chunks to time windows of the given length, counted from
+	// the Unix epoch. For example, a window of one hour never lets a chunk cross a
+	// full hour (in UTC), so that all chunks of an hour can be found by the hour.
+	Window time.Duration
+}
+
+// split splits the points into chunks. Empty point slices have no chunks.
+func (p ChunkingPolicy) split(points []Point) [][]Point {
+	if len(points) == 0 {
+		return nil
+	}
+
+	maxSpan := durationMillis(p.MaxSpan)
+	window := durationMillis(p.Window)
+
+	var chunks [][]Point
+	first := 0
+	for i := 1; i < len(points); i++ {
+		start, ts := points[first].Timestamp, points[i].Timestamp
+		if (p.MaxPoints > 0 && i-first >= p.MaxPoints) ||
+			(maxSpan > 0 && ts-start > maxSpan) ||
+			(window > 0 && windowOf(ts, window) != windowOf(start, window)) {
+			chunks = append(chunks, points[first:i])
+			first = i
+		}
+	}
+	return append(chunks, points[first:])
+}
+
+// durationMillis returns the duration in milliseconds, rounded up so that
+// positive durations are never 0.
+func durationMillis(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+// windowOf returns the number of the window a timestamp belongs to.
+func windowOf(ts, window int64) int64 {
+	w := ts / window
+	if ts < 0 && ts%window != 0 {
+		w--
+	}
+	return w
+}
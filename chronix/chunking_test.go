@@ -0,0 +1,122 @@
+package chronix
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func chunkBounds(chunks [][]Point) [][2]int64 {
+	bounds := make([][2]int64, 0, len(chunks))
+	for _, c := range chunks {
+		bounds = append(bounds, [2]int64{c[0].Timestamp, c[len(c)-1].Timestamp})
+	}
+	return bounds
+}
+
+func TestChunkingPolicySplit(t *testing.T) {
+	tests := []struct {
+		policy ChunkingPolicy
+		points []Point
+		want   [][2]int64
+	}{
+		{
+			policy: ChunkingPolicy{},
+			points: pointsFrom(0, 10),
+			want:   [][2]int64{{0, 9}},
+		},
+		{
+			policy: ChunkingPolicy{MaxPoints: 4},
+			points: pointsFrom(0, 10),
+			want:   [][2]int64{{0, 3}, {4, 7}, {8, 9}},
+		},
+		{
+			policy: ChunkingPolicy{MaxSpan: 3 * time.Millisecond},
+			points: pointsFrom(0, 10),
+			want:   [][2]int64{{0, 3}, {4, 7}, {8, 9}},
+		},
+		{
+			policy: ChunkingPolicy{Window: 5 * time.Millisecond},
+			points: pointsFrom(3, 10),
+			want:   [][2]int64{{3, 4}, {5, 9}, {10, 12}},
+		},
+		{
+			policy: ChunkingPolicy{Window: 5 * time.Millisecond},
+			points: pointsFrom(-6, 4),
+			want:   [][2]int64{{-6, -6}, {-5, -3}},
+		},
+		{
+			policy: ChunkingPolicy{MaxPoints: 2, Window: 5 * time.Millisecond},
+			points: pointsFrom(3, 5),
+			want:   [][2]int64{{3, 4}, {5, 6}, {7, 7}},
+		},
+	}
+
+	for i, test := range tests {
+		got := chunkBounds(test.policy.split(test.points))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d. Unexpected chunks. Want %v, got %v", i, test.want, got)
+		}
+	}
+
+	if chunks := (ChunkingPolicy{MaxPoints: 2}).split(nil); len(chunks) != 0 {
+		t.Errorf("Expected no chunks for no points, got %v", chunks)
+	}
+}
+
+func TestStoreWithChunking(t *testing.T) {
+	storage := &recordingStorage{}
+	c := NewWithOptions(storage, Options{
+		CreateStatistics: true,
+		Chunking:         ChunkingPolicy{MaxPoints: 4},
+	})
+
+	series := &TimeSeries{
+		Name:       "testmetric",
+		Type:       "metric",
+		Attributes: map[string]string{"host": "testhost"},
+		Points:     pointsFrom(100, 10),
+	}
+	if err := c.Store([]*TimeSeries{series}, false, 0); err != nil {
+		t.Fatal("Error storing series:", err)
+	}
+
+	docs := storage.documents()
+	if len(docs) != 3 {
+		t.Fatalf("Expected 3 chunks, got %d", len(docs))
+	}
+	want := []struct {
+		start, end int64
+		count      int64
+		min, max   float64
+	}{
+		{100, 103, 4, 100, 103},
+		{104, 107, 4, 104, 107},
+		{108, 109, 2, 108, 109},
+	}
+	for i, doc := range docs {
+		w := want[i]
+		if doc["start"] != w.start || doc["end"] != w.end {
+			t.Errorf("%d. Unexpected chunk range %v-%v", i, doc["start"], doc["end"])
+		}
+		if doc["stats_count_f"] != w.count || doc["stats_min_f"] != w.min || doc["stats_max_f"] != w.max {
+			t.Errorf("%d. Unexpected chunk statistics %v", i, doc)
+		}
+		if doc["host_s"] != "testhost" || doc["name"] != "testmetric" {
+			t.Errorf("%d. Unexpected chunk attributes %v", i, doc)
+		}
+
+		data, err := base64.StdEncoding.DecodeString(doc["data"].(string))
+		if err != nil {
+			t.Fatalf("%d. Error decoding base64 data: %v", i, err)
+		}
+		points, err := decode(data, w.start, w.end, w.start, w.end)
+		if err != nil {
+			t.Fatalf("%d. Error decoding chunk: %v", i, err)
+		}
+		if !reflect.DeepEqual(points, series.Points[w.start-100:w.end-100+1]) {
+			t.Errorf("%d. Unexpected chunk points %v", i, points)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package chronix
 
 import (
+	"context"
 	"time"
 	"encoding/base64"
 	"fmt"
@@ -8,21 +9,70 @@ import (
 
 // Client is a client that allows storing time series in Chronix.
 type Client interface {
+	// Store stores the series in the storage. The points of each series are sorted by their
+	// timestamps first. An *InvalidSeriesError is returned for series that cannot be stored.
 	Store(ts []*TimeSeries, commit bool, commitWithin time.Duration) error
-	// TODO: Return a more interpreted query result on the Chronix level.
+	// StoreContext is like Store but uses ctx for the storage request.
+	StoreContext(ctx context.Context, ts []*TimeSeries, commit bool, commitWithin time.Duration) error
+	// Query returns the raw response of the storage.
 	Query(q, fq, fl string) ([]byte, error)
+	// QueryContext is like Query but uses ctx for the storage request.
+	QueryContext(ctx context.Context, q, fq, fl string) ([]byte, error)
+	// QuerySeries returns the decoded time series chunks matching the query.
+	QuerySeries(q, fq string) ([]*TimeSeries, error)
+	// QuerySeriesContext is like QuerySeries but uses ctx for the storage request.
+	QuerySeriesContext(ctx context.Context, q, fq string) ([]*TimeSeries, error)
+	// QueryWithParams returns the raw response of the storage for a query built by a QueryBuilder.
+	QueryWithParams(ctx context.Context, params QueryParams) ([]byte, error)
+	// QuerySeriesWithParams returns the decoded time series chunks for a query built by a
+	// QueryBuilder, including the results of the requested Chronix functions.
+	QuerySeriesWithParams(ctx context.Context, params QueryParams) ([]*TimeSeries, error)
+	// IterateSeries returns an iterator over the time series chunks matching the query.
+	// The chunks are requested in pages of pageSize documents (DefaultPageSize if 0).
+	// Queries with joins or functions fail with ErrPagedJoinOrFunction.
+	IterateSeries(ctx context.Context, params QueryParams, pageSize int) *SeriesIterator
+}
+
+// Options configures a Chronix client.
+type Options struct {
+	// CreateStatistics enables the creation of statistics for the individual data chunks.
+	CreateStatistics bool
+	// DDCThreshold is the date-delta-compaction threshold in milliseconds. Timestamp deltas
+	// that differ by at most the threshold are not stored, which reduces the chunk size of
+	// series with almost regular timestamps. In exchange, the decoded timestamps may deviate
+	// from the stored ones by up to the threshold. The first and last timestamp of a chunk are
+	// always exact. A threshold of 0 stores every timestamp exactly.
+	DDCThreshold uint32
+	// Chunking configures how series are split into chunks when storing them. By default,
+	// every series is stored as one chunk.
+	Chunking ChunkingPolicy
+	// Duplicates defines how points of a series with the same timestamp are handled. Points
+	// are sorted by their timestamps before storing them; by default, the first of the points
+	// with the same timestamp is kept.
+	Duplicates DuplicatePolicy
+	// Statistics selects the optional statistics stored in addition to the count, min, max,
+	// avg and timespan, e.g. StatSum | StatP99. They are only created if CreateStatistics is set.
+	Statistics Statistic
+	// SpecialValues defines how NaN and infinite values are treated by the statistics. By
+	// default, the statistics are calculated from the finite values only.
+	SpecialValues SpecialValuePolicy
+	// StatsCalculators calculate additional statistics for the individual data chunks. They
+	// are used regardless of CreateStatistics.
+	StatsCalculators []StatsCalculator
 }
 
 type client struct {
 	storage StorageClient
-	createStatistics bool
+	ddcThreshold uint32
+	chunking ChunkingPolicy
+	duplicates DuplicatePolicy
+	calculators []StatsCalculator
 }
 
 // New creates a new Chronix client. The client does not create statistics for the individual data chunks in the storage.
 func New(s StorageClient) Client {
 	return &client{
 		storage: s,
-		createStatistics: false,
 	}
 }
 
@@ -30,77 +80,139 @@ func New(s StorageClient) Client {
 func NewWithStatistics(s StorageClient) Client {
 	return &client{
 		storage: s,
-		createStatistics: true,
+		calculators: []StatsCalculator{builtinStats{}},
+	}
+}
+
+// NewWithOptions creates a new Chronix client with the given options.
+func NewWithOptions(s StorageClient, opts Options) Client {
+	var calculators []StatsCalculator
+	if opts.CreateStatistics {
+		calculators = append(calculators, builtinStats{extra: opts.Statistics, special: opts.SpecialValues})
+	}
+	return &client{
+		storage: s,
+		ddcThreshold: opts.DDCThreshold,
+		chunking: opts.Chunking,
+		duplicates: opts.Duplicates,
+		calculators: append(calculators, opts.StatsCalculators...),
 	}
 }
 
 func (c *client) Store(series []*TimeSeries, commit bool, commitWithin time.Duration) error {
+	return c.StoreContext(context.Background(), series, commit, commitWithin)
+}
+
+func (c *client) StoreContext(ctx context.Context, series []*TimeSeries, commit bool, commitWithin time.Duration) error {
 	if len(series) == 0 {
 		return nil
 	}
 
 	var update []map[string]interface{}
+	postfix := c.storage.NeedPostfixOnDynamicField()
 	for _, ts := range series {
-		if len(ts.Points) == 0 {
-			continue
+		if err := checkAttributes(ts, postfix); err != nil {
+			return err
 		}
-
-		data, err := encode(ts.Points, 0)
+		ts, err := normalize(ts, c.duplicates)
 		if err != nil {
-			return fmt.Errorf("error encoding points: %v", err)
-		}
-		encData := base64.StdEncoding.EncodeToString(data)
-		fields := map[string]interface{}{
-			"start": ts.Points[0].Timestamp,
-			"end":   ts.Points[len(ts.Points)-1].Timestamp,
-			"data":  encData,
-			"name":  ts.Name,
-			"type":  ts.Type,
+			return err
 		}
-
-		if c.storage.NeedPostfixOnDynamicField() {
-			for k, v := range ts.Attributes {
-				fields[k+"_s"] = v
-			}
-		} else {
-			for k, v := range ts.Attributes {
-				fields[k] = v
+		for _, chunk := range c.chunking.split(ts.Points) {
+			chunkSeries := *ts
+			chunkSeries.Points = chunk
+			fields, err := c.toDocument(&chunkSeries)
+			if err != nil {
+				return err
 			}
+			update = append(update, fields)
 		}
-
-		err = c.addStatistics(ts, &fields)
-		if err != nil {
-			return fmt.Errorf("error adding statistics: %v", err)
-		}
-
-		update = append(update, fields)
 	}
-	return c.storage.Update(update, commit, commitWithin)
+	return c.storage.UpdateContext(ctx, update, commit, commitWithin)
 }
 
-func (c *client) addStatistics(series *TimeSeries, fields *map[string]interface{}) error {
-	if !c.createStatistics {
-		return nil
+// toDocument converts a time series chunk into a storage document.
+func (c *client) toDocument(ts *TimeSeries) (map[string]interface{}, error) {
+	ddcThreshold := c.ddcThreshold
+	if ts.DDCThreshold != nil {
+		ddcThreshold = *ts.DDCThreshold
 	}
 
-	stats, err := calculateStats(series)
+	data, err := encode(ts.Points, ddcThreshold)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error encoding points: %v", err)
+	}
+	encData := base64.StdEncoding.EncodeToString(data)
+	fields := map[string]interface{}{
+		"start": ts.Points[0].Timestamp,
+		"end":   ts.Points[len(ts.Points)-1].Timestamp,
+		"data":  encData,
+		"name":  ts.Name,
+		"type":  ts.Type,
 	}
 
-	suffix := ""
-	if c.storage.NeedPostfixOnDynamicField() {
-		suffix = "_f"
+	for k, v := range ts.Attributes {
+		fields[c.storage.DynamicFieldName(k, v)] = v
 	}
 
-	(*fields)["stats_timespan" + suffix] = stats.timespan
-	(*fields)["stats_count" + suffix] = stats.count
-	(*fields)["stats_min" + suffix] = stats.min
-	(*fields)["stats_max" + suffix] = stats.max
-	(*fields)["stats_avg" + suffix] = stats.avg
+	err = c.addStatistics(ts, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("error adding statistics: %v", err)
+	}
+	return fields, nil
+}
+
+func (c *client) addStatistics(series *TimeSeries, fields *map[string]interface{}) error {
+	for _, calculator := range c.calculators {
+		stats, err := calculator.Calculate(series)
+		if err != nil {
+			return err
+		}
+		for name, value := range stats {
+			field, value, err := statField(c.storage, name, value)
+			if err != nil {
+				return err
+			}
+			if _, ok := (*fields)[field]; ok {
+				return fmt.Errorf("statistic %s collides with field %s", name, field)
+			}
+			(*fields)[field] = value
+		}
+	}
 	return nil
 }
 
 func (c *client) Query(q, fq, fl string) ([]byte, error) {
-	return c.storage.Query(q, fq, fl)
+	return c.QueryContext(context.Background(), q, fq, fl)
+}
+
+func (c *client) QueryContext(ctx context.Context, q, fq, fl string) ([]byte, error) {
+	return c.storage.QueryContext(ctx, q, fq, fl)
+}
+
+func (c *client) QuerySeries(q, fq string) ([]*TimeSeries, error) {
+	return c.QuerySeriesContext(context.Background(), q, fq)
+}
+
+func (c *client) QuerySeriesContext(ctx context.Context, q, fq string) ([]*TimeSeries, error) {
+	return c.QuerySeriesWithParams(ctx, QueryParams{Q: q, CJ: fq})
+}
+
+func (c *client) QueryWithParams(ctx context.Context, params QueryParams) ([]byte, error) {
+	return c.storage.QueryWithParams(ctx, params)
+}
+
+func (c *client) QuerySeriesWithParams(ctx context.Context, params QueryParams) ([]*TimeSeries, error) {
+	if params.FL == "" {
+		params.FL = "*"
+	}
+	resp, err := c.storage.QueryWithParams(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return parseQueryResponse(resp, c.storage.NeedPostfixOnDynamicField())
+}
+
+func (c *client) IterateSeries(ctx context.Context, params QueryParams, pageSize int) *SeriesIterator {
+	return newSeriesIterator(ctx, c.storage, params, pageSize)
 }
@@ -1,7 +1,9 @@
 package chronix
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -44,6 +46,9 @@ func createElasticMock(reference string, t *testing.T) *httptest.Server {
 		if !reflect.DeepEqual(want, got) {
 			t.Fatalf("Unexpected request body. Want:\n\n%v\n\nGot:\n\n%v", want, got)
 		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
 	}))
 	return server
 }
@@ -93,8 +98,11 @@ func readReference(reference string, t *testing.T) []interface{} {
 	return result
 }
 
-func createElasticClient(server *httptest.Server, createStatistics bool) Client {
-	elastic := NewElasticTestStorage(&server.URL)
+func createElasticClient(server *httptest.Server, createStatistics bool, t *testing.T) Client {
+	elastic, err := NewElasticTestStorage(&server.URL)
+	if err != nil {
+		t.Fatal("Error creating Elastic storage:", err)
+	}
 
 	if createStatistics {
 		return NewWithStatistics(elastic)
@@ -107,7 +115,7 @@ func TestElasticUpdateEndToEnd(t *testing.T) {
 	server := createElasticMock("reference.txt", t)
 	defer server.Close()
 
-	c := createElasticClient(server, false)
+	c := createElasticClient(server, false, t)
 
 	series := genTimeSeries()
 
@@ -120,7 +128,7 @@ func TestElasticUpdateWithStatisticsEndToEnd(t *testing.T) {
 	server := createElasticMock("referenceWithStatistics.txt", t)
 	defer server.Close()
 
-	c := createElasticClient(server, true)
+	c := createElasticClient(server, true, t)
 
 	series := genTimeSeries()
 
@@ -129,6 +137,277 @@ func TestElasticUpdateWithStatisticsEndToEnd(t *testing.T) {
 	}
 }
 
+func createElasticSearchMock(reference string, hits []map[string]interface{}, t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chronix/_search" {
+			t.Fatal("Unexpected path:", r.URL.Path)
+		}
+		if r.Method != "POST" {
+			t.Fatal("Unexpected method:", r.Method)
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal("Error reading request body:", err)
+		}
+		var got interface{}
+		if err = json.Unmarshal(body, &got); err != nil {
+			t.Fatal("Error unmarshalling body:", err)
+		}
+
+		referenceFile := filepath.Join("fixtures", "elastic", reference)
+		writeReferenceJson(got, t, referenceFile)
+
+		f, err := ioutil.ReadFile(referenceFile)
+		if err != nil {
+			t.Fatal("Error reading fixture file:", err)
+		}
+		var want interface{}
+		if err := json.Unmarshal(f, &want); err != nil {
+			t.Fatal("Error unmarshalling fixture:", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Unexpected search request. Want:\n\n%v\n\nGot:\n\n%v", want, got)
+		}
+
+		esHits := make([]map[string]interface{}, 0, len(hits))
+		for i, hit := range hits {
+			esHits = append(esHits, map[string]interface{}{
+				"_index":  "chronix",
+				"_type":   "doc",
+				"_id":     fmt.Sprintf("%d", i),
+				"_source": hit,
+			})
+		}
+		resp, err := json.Marshal(map[string]interface{}{
+			"took": 1,
+			"hits": map[string]interface{}{
+				"total": len(hits),
+				"hits":  esHits,
+			},
+		})
+		if err != nil {
+			t.Fatal("Error marshalling response:", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	}))
+}
+
 func TestElasticQueryEndToEnd(t *testing.T) {
-	//not yet implemented
+	points := buildTestPoints()
+	data, err := encode(points, 0)
+	if err != nil {
+		t.Fatal("Error encoding points:", err)
+	}
+	hit := map[string]interface{}{
+		"name":  "testmetric",
+		"type":  "metric",
+		"host":  "testhost",
+		"start": points[0].Timestamp,
+		"end":   points[len(points)-1].Timestamp,
+		"data":  base64.StdEncoding.EncodeToString(data),
+	}
+
+	q := "name:(testmetric) AND host:(testhost OR otherhost) AND start:15 AND end:114"
+	server := createElasticSearchMock("query.json", []map[string]interface{}{hit}, t)
+	defer server.Close()
+
+	c := createElasticClient(server, false, t)
+
+	// Raw results have the shape of a Solr response.
+	raw, err := c.Query(q, "", "")
+	if err != nil {
+		t.Fatal("Error querying:", err)
+	}
+	var resp struct {
+		Response struct {
+			NumFound int                      `json:"numFound"`
+			Docs     []map[string]interface{} `json:"docs"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		t.Fatal("Error unmarshalling response:", err)
+	}
+	if resp.Response.NumFound != 1 || len(resp.Response.Docs) != 1 || resp.Response.Docs[0]["host"] != "testhost" {
+		t.Fatalf("Unexpected raw response: %s", raw)
+	}
+
+	series, err := c.QuerySeries(q, "")
+	if err != nil {
+		t.Fatal("Error querying:", err)
+	}
+	want := []*TimeSeries{
+		{
+			Name:       "testmetric",
+			Type:       "metric",
+			Attributes: map[string]string{"host": "testhost"},
+			Points:     points,
+		},
+	}
+	if !reflect.DeepEqual(series, want) {
+		t.Fatalf("Unexpected series. Want:\n\n%v\n\nGot:\n\n%v", want, series)
+	}
+}
+
+func TestElasticQueryTruncated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"took":1,"hits":{"total":20000,"hits":[{"_index":"chronix","_type":"doc","_id":"1","_source":{"name":"a"}}]}}`)
+	}))
+	defer server.Close()
+
+	c := createElasticClient(server, false, t)
+	_, err := c.QuerySeries("name:a", "")
+	if e, ok := err.(*TruncatedResultError); !ok || e.Total != 20000 || e.Returned != 1 {
+		t.Fatalf("Expected a *TruncatedResultError, got %v", err)
+	}
+}
+
+func TestTranslateQueryErrors(t *testing.T) {
+	for _, q := range []string{"name", "name:(a OR b", "start:yesterday", "name:\"a"} {
+		if _, err := translateQuery(q, time.Now()); err == nil {
+			t.Errorf("Expected an error for query %s", q)
+		}
+	}
+}
+
+func TestElasticUpdateRetriesFailedDocuments(t *testing.T) {
+	var requests [][]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal("Error reading request body:", err)
+		}
+		requests = append(requests, parseReceived(body, t))
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(requests) == 1 {
+			w.Write([]byte(`{"took":1,"errors":true,"items":[
+				{"index":{"_index":"chronix","_type":"doc","_id":"1","status":201}},
+				{"index":{"_index":"chronix","_type":"doc","_id":"2","status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}}
+			]}`))
+			return
+		}
+		w.Write([]byte(`{"took":1,"errors":false,"items":[{"index":{"_index":"chronix","_type":"doc","_id":"2","status":201}}]}`))
+	}))
+	defer server.Close()
+
+	storage, err := NewElasticTestStorage(&server.URL)
+	if err != nil {
+		t.Fatal("Error creating Elastic storage:", err)
+	}
+	storage.(*elasticClient).retry = RetryPolicy{
+		MaxAttempts:          3,
+		InitialBackoff:       time.Millisecond,
+		RetryableStatusCodes: []int{http.StatusTooManyRequests},
+	}
+
+	docs := []map[string]interface{}{{"name": "first"}, {"name": "second"}}
+	if err := storage.Update(docs, false, 0); err != nil {
+		t.Fatal("Expected the update to succeed after a retry, got", err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 bulk requests, got %d", len(requests))
+	}
+	// The retry only contains the action and source of the rejected document.
+	if len(requests[1]) != 2 || !reflect.DeepEqual(requests[0][2:], requests[1]) {
+		t.Fatalf("Expected only the failed document to be retried, got %v", requests[1])
+	}
+	action := requests[1][0].(map[string]interface{})["index"].(map[string]interface{})
+	if action["_id"] == nil {
+		t.Error("Expected the retried document to have an id")
+	}
+}
+
+func TestElasticUpdateReturnsFailuresOfEarlierAttempts(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		if requests == 1 {
+			w.Write([]byte(`{"took":1,"errors":true,"items":[
+				{"index":{"_index":"chronix","_type":"doc","_id":"1","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}},
+				{"index":{"_index":"chronix","_type":"doc","_id":"2","status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}}
+			]}`))
+			return
+		}
+		w.Write([]byte(`{"took":1,"errors":false,"items":[{"index":{"_index":"chronix","_type":"doc","_id":"2","status":201}}]}`))
+	}))
+	defer server.Close()
+
+	storage, err := NewElasticTestStorage(&server.URL)
+	if err != nil {
+		t.Fatal("Error creating Elastic storage:", err)
+	}
+	storage.(*elasticClient).retry = RetryPolicy{
+		MaxAttempts:          3,
+		InitialBackoff:       time.Millisecond,
+		RetryableStatusCodes: []int{http.StatusTooManyRequests},
+	}
+
+	docs := []map[string]interface{}{{"name": "first"}, {"name": "second"}}
+	err = storage.Update(docs, false, 0)
+	bulkErr, ok := err.(*BulkError)
+	if !ok {
+		t.Fatalf("Expected a *BulkError, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 bulk requests, got %d", requests)
+	}
+	if bulkErr.Total != 2 || len(bulkErr.Failed) != 1 || bulkErr.Failed[0].Status != 400 {
+		t.Fatalf("Unexpected bulk error: %+v", bulkErr)
+	}
+	if !reflect.DeepEqual(bulkErr.Documents(), docs[:1]) {
+		t.Errorf("Unexpected failed documents: %v", bulkErr.Documents())
+	}
+}
+
+func TestElasticUpdateReturnsFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"took":1,"errors":true,"items":[
+			{"index":{"_index":"chronix","_type":"doc","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}
+		]}`))
+	}))
+	defer server.Close()
+
+	storage, err := NewElasticTestStorage(&server.URL)
+	if err != nil {
+		t.Fatal("Error creating Elastic storage:", err)
+	}
+	docs := []map[string]interface{}{{"name": "first"}}
+	err = storage.Update(docs, false, 0)
+	bulkErr, ok := err.(*BulkError)
+	if !ok {
+		t.Fatalf("Expected a *BulkError, got %v", err)
+	}
+
+	want := []BulkItemError{
+		{Index: "chronix", Status: 400, Type: "mapper_parsing_exception", Reason: "failed to parse", Document: docs[0]},
+	}
+	if bulkErr.Total != 1 || !reflect.DeepEqual(bulkErr.Failed, want) {
+		t.Fatalf("Unexpected bulk error: %+v", bulkErr)
+	}
+	if !reflect.DeepEqual(bulkErr.Documents(), docs) {
+		t.Errorf("Unexpected failed documents: %v", bulkErr.Documents())
+	}
+	wantMsg := "1 of 1 documents failed to index, first error: index chronix, status 400, mapper_parsing_exception: failed to parse"
+	if bulkErr.Error() != wantMsg {
+		t.Errorf("Unexpected error message: %s", bulkErr.Error())
+	}
+}
+
+func TestElasticUpdateReturnsNetworkErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	storage, err := NewElasticTestStorage(&server.URL)
+	if err != nil {
+		t.Fatal("Error creating Elastic storage:", err)
+	}
+	server.Close()
+
+	if err := storage.Update([]map[string]interface{}{{"name": "first"}}, false, 0); err == nil {
+		t.Fatal("Expected an error for the unreachable server")
+	}
 }
@@ -1,6 +1,8 @@
 package chronix
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -13,6 +15,7 @@ import (
 	"flag"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 var update = flag.Bool("update", false, "update reference json files")
@@ -191,3 +194,185 @@ func TestQueryEndToEnd(t *testing.T) {
 		t.Fatalf("Unexpected result JSON; want %s, got %s", resultJSON, string(res))
 	}
 }
+
+func TestQuerySeriesEndToEnd(t *testing.T) {
+	q := "name:(testmetric) AND start:15 AND end:114"
+	cj := "host_s,name"
+
+	points := buildTestPoints()
+	data, err := encode(points, 0)
+	if err != nil {
+		t.Fatal("Error encoding points:", err)
+	}
+	resultJSON, err := json.Marshal(map[string]interface{}{
+		"response": map[string]interface{}{
+			"numFound": 1,
+			"docs": []map[string]interface{}{
+				{
+					"name":          "testmetric",
+					"type":          "metric",
+					"start":         points[0].Timestamp,
+					"end":           points[len(points)-1].Timestamp,
+					"data":          base64.StdEncoding.EncodeToString(data),
+					"host_s":        "testhost",
+					"stats_count_f": 100,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal("Error marshalling result:", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+		if qs.Get("q") != q || qs.Get("cj") != cj {
+			t.Fatalf("Unexpected query params: %v", qs)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resultJSON)
+	}))
+	defer server.Close()
+
+	c, err := createSolrClient(server, t, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	series, err := c.QuerySeries(q, cj)
+	if err != nil {
+		t.Fatal("Error querying:", err)
+	}
+
+	want := []*TimeSeries{
+		{
+			Name:       "testmetric",
+			Type:       "metric",
+			Attributes: map[string]string{"host": "testhost"},
+			Points:     points,
+		},
+	}
+	if !reflect.DeepEqual(series, want) {
+		t.Fatalf("Unexpected series. Want:\n\n%v\n\nGot:\n\n%v", want, series)
+	}
+}
+
+// A StorageClient that records the updated documents.
+type recordingStorage struct {
+	mtx     sync.Mutex
+	updates []map[string]interface{}
+	err     error
+}
+
+func (s *recordingStorage) Update(data []map[string]interface{}, commit bool, commitWithin time.Duration) error {
+	return s.UpdateContext(context.Background(), data, commit, commitWithin)
+}
+
+func (s *recordingStorage) UpdateContext(ctx context.Context, data []map[string]interface{}, commit bool, commitWithin time.Duration) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.updates = append(s.updates, data...)
+	return nil
+}
+
+func (s *recordingStorage) documents() []map[string]interface{} {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]map[string]interface{}(nil), s.updates...)
+}
+
+func (s *recordingStorage) Query(q, fq, fl string) ([]byte, error) {
+	return nil, nil
+}
+
+func (s *recordingStorage) QueryContext(ctx context.Context, q, fq, fl string) ([]byte, error) {
+	return nil, nil
+}
+
+func (s *recordingStorage) QueryWithParams(ctx context.Context, params QueryParams) ([]byte, error) {
+	return nil, nil
+}
+
+func (s *recordingStorage) QueryPage(ctx context.Context, params QueryParams, cursor string, rows int) (*ResultPage, error) {
+	return &ResultPage{}, nil
+}
+
+func (s *recordingStorage) CloseCursor(ctx context.Context, cursor string) error {
+	return nil
+}
+
+func (s *recordingStorage) NeedPostfixOnDynamicField() bool {
+	return true
+}
+
+func (s *recordingStorage) DynamicFieldName(name string, value interface{}) string {
+	return PostfixFieldName(name, value)
+}
+
+func TestStoreWithDDCThreshold(t *testing.T) {
+	points := buildJitteredPoints()
+	storage := &recordingStorage{}
+	c := NewWithOptions(storage, Options{DDCThreshold: 10})
+
+	series := []*TimeSeries{
+		{Name: "global", Type: "metric", Points: points},
+		{Name: "override", Type: "metric", Points: points, DDCThreshold: OverrideDDC(50)},
+		{Name: "exact", Type: "metric", Points: points, DDCThreshold: OverrideDDC(0)},
+	}
+	if err := c.Store(series, false, 0); err != nil {
+		t.Fatal("Error storing time series:", err)
+	}
+
+	for i, threshold := range []uint32{10, 50, 0} {
+		data, err := encode(points, threshold)
+		if err != nil {
+			t.Fatal("Failed to encode points: ", err)
+		}
+		want := base64.StdEncoding.EncodeToString(data)
+		if got := storage.updates[i]["data"]; got != want {
+			t.Errorf("Expected series %s to be encoded with threshold %d", series[i].Name, threshold)
+		}
+	}
+}
+
+func TestQueryContextCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	c, err := createSolrClient(server, t, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = c.QueryContext(ctx, "name:testmetric", "", ""); err == nil {
+		t.Fatal("Expected an error for the canceled query")
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Fatal("Expected the context deadline to be exceeded, got", ctx.Err())
+	}
+}
+
+func TestStoreContextCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Unexpected request for a canceled context")
+	}))
+	defer server.Close()
+
+	c, err := createSolrClient(server, t, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = c.StoreContext(ctx, genTimeSeries(), true, time.Second); err == nil {
+		t.Fatal("Expected an error for the canceled update")
+	}
+}
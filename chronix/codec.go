@@ -11,6 +11,51 @@ import (
 	"github.com/golang/protobuf/proto"
 )
 
+// EncodePoints encodes the points in the format used by the Chronix server:
+// a gzip-compressed protocol buffer message as defined in MetricPoint.proto.
+// The points have to be sorted by timestamp, otherwise an error is returned.
+//
+// The date-delta-compaction (DDC) threshold is given in milliseconds. Deltas
+// between timestamps that differ by at most the threshold are not stored, which
+// results in smaller chunks at the cost of timestamp accuracy. A threshold of 0
+// stores every timestamp exactly.
+//
+// The encoded protocol buffer message can be read by the Java Chronix server and
+// vice versa. The compressed bytes themselves may differ from the ones produced by
+// the Java implementation, as gzip output depends on the compressor in use.
+func EncodePoints(points []Point, ddcThreshold uint32) ([]byte, error) {
+	return encode(points, ddcThreshold)
+}
+
+// DecodePoints decodes points that were encoded by EncodePoints or the Chronix
+// server. tsStart and tsEnd are the start and end timestamps of the chunk as stored
+// in its document. Only points with a timestamp within [from, to] are returned.
+// Malformed data results in an error.
+func DecodePoints(data []byte, tsStart, tsEnd, from, to int64) ([]Point, error) {
+	return decode(data, tsStart, tsEnd, from, to)
+}
+
+// pointValue returns the value of the i-th point, which is either stored in the
+// point itself or in the point referenced by its value index.
+func pointValue(points []*pb.Point, i int) (float64, error) {
+	p := points[i]
+	if p.VIndex == nil {
+		if p.V == nil {
+			return 0, fmt.Errorf("point %d has no value", i)
+		}
+		return p.GetV(), nil
+	}
+
+	ref := p.GetVIndex()
+	if uint64(ref) >= uint64(len(points)) {
+		return 0, fmt.Errorf("value index %d of point %d is out of range [0, %d)", ref, i, len(points))
+	}
+	if points[ref] == nil || points[ref].V == nil {
+		return 0, fmt.Errorf("value index %d of point %d references a point without value", ref, i)
+	}
+	return points[ref].GetV(), nil
+}
+
 // decode decodes a serialized stream of points.
 func decode(compressed []byte, tsStart, tsEnd, from, to int64) ([]Point, error) {
 	if from == -1 || to == -1 {
@@ -50,6 +95,9 @@ func decode(compressed []byte, tsStart, tsEnd, from, to int64) ([]Point, error)
 	points := make([]Point, 0, len(pbPoints.P))
 
 	for i, p := range pbPoints.P {
+		if p == nil {
+			return nil, fmt.Errorf("point %d is missing", i)
+		}
 		// Decode the time.
 		if i > 0 {
 			lastDelta = getTimestamp(p, lastDelta)
@@ -58,11 +106,9 @@ func decode(compressed []byte, tsStart, tsEnd, from, to int64) ([]Point, error)
 
 		// Only add the point if it is within the selected range.
 		if calculatedPointDate >= from && calculatedPointDate <= to {
-			var value float64
-			if p.VIndex != nil {
-				value = pbPoints.P[p.GetVIndex()].GetV()
-			} else {
-				value = p.GetV()
+			value, err := pointValue(pbPoints.P, i)
+			if err != nil {
+				return nil, err
 			}
 			points = append(points, Point{
 				Timestamp: calculatedPointDate,
@@ -89,18 +135,23 @@ func encode(points []Point, ddcThreshold uint32) ([]byte, error) {
 		timesSinceLastDelta int32
 	)
 
-	valueIndex := map[float64]uint32{}
+	// The value index is keyed by the bits of the values to tell 0 from -0.
+	valueIndex := map[uint64]uint32{}
 
 	var pbPoints pb.Points
 	pbPoints.P = make([]*pb.Point, 0, len(points))
 
 	var index uint32
 	for i, p := range points {
+		if i > 0 && p.Timestamp < prevDate {
+			return nil, fmt.Errorf("points are not sorted by timestamp: %d follows %d", p.Timestamp, prevDate)
+		}
+
 		var pbPoint pb.Point
 		currentTimestamp := p.Timestamp
 		// Add value or index, if the value already exists.
 		setValueOrRefIndexOnPoint(valueIndex, index, p.Value, &pbPoint)
-		if prevDate == 0 {
+		if i == 0 {
 			// Set lastStoredDate to the value of the first timestamp.
 			lastStoredDate = currentTimestamp
 			startDate = currentTimestamp
@@ -110,7 +161,9 @@ func encode(points []Point, ddcThreshold uint32) ([]byte, error) {
 
 		// Last point.
 		if i == len(points)-1 {
-			handleLastPoint(ddcThreshold, startDate, &pbPoint, &pbPoints, currentTimestamp)
+			if err := handleLastPoint(ddcThreshold, startDate, &pbPoint, &pbPoints, currentTimestamp); err != nil {
+				return nil, err
+			}
 			break
 		}
 
@@ -180,28 +233,36 @@ func getTimestamp(p *pb.Point, lastOffset int64) int64 {
 	return lastOffset
 }
 
-func handleLastPoint(ddcThreshold uint32, startDate int64, point *pb.Point, points *pb.Points, currentTimestamp int64) {
-	calcPoint := calculateTimestamp(startDate, points.P, ddcThreshold)
+func handleLastPoint(ddcThreshold uint32, startDate int64, point *pb.Point, points *pb.Points, currentTimestamp int64) error {
+	calcPoint, lastDelta := calculateTimestampAndDelta(startDate, points.P, ddcThreshold)
 
 	// Calculate offset.
 	deltaToLastTimestamp := currentTimestamp - calcPoint
 
+	// A point without a delta repeats the last one. Like the Java server, the
+	// delta is omitted if that results in the exact timestamp.
+	if len(points.P) > 0 && deltaToLastTimestamp == lastDelta {
+		points.P = append(points.P, point)
+		return nil
+	}
+
 	// Everything okay?
 	if deltaToLastTimestamp >= 0 {
 		setTimestamp(point, deltaToLastTimestamp)
 		points.P = append(points.P, point)
-	} else {
-		// We have to rearrange the points as we are already behind the actual end timestamp.
-		rearrangePoints(startDate, currentTimestamp, deltaToLastTimestamp, ddcThreshold, points, point)
+		return nil
 	}
+	// We have to rearrange the points as we are already behind the actual end timestamp.
+	return rearrangePoints(startDate, currentTimestamp, deltaToLastTimestamp, ddcThreshold, points, point)
 }
 
-func setValueOrRefIndexOnPoint(index map[float64]uint32, currentPointIndex uint32, value float64, point *pb.Point) {
+func setValueOrRefIndexOnPoint(index map[uint64]uint32, currentPointIndex uint32, value float64, point *pb.Point) {
 	// Build value index.
-	if i, exists := index[value]; exists {
+	bits := math.Float64bits(value)
+	if i, exists := index[bits]; exists {
 		point.VIndex = proto.Uint32(i)
 	} else {
-		index[value] = currentPointIndex
+		index[bits] = currentPointIndex
 		point.V = proto.Float64(value)
 	}
 }
@@ -222,7 +283,7 @@ func setBPTimestamp(point *pb.Point, timestampDelta int64) {
 	}
 }
 
-func rearrangePoints(startDate int64, currentTimestamp int64, deltaToEndTimestamp int64, ddcThreshold uint32, points *pb.Points, point *pb.Point) {
+func rearrangePoints(startDate int64, currentTimestamp int64, deltaToEndTimestamp int64, ddcThreshold uint32, points *pb.Points, point *pb.Point) error {
 	// Break the offset down on all points.
 	avgPerDelta := int64(math.Ceil(float64(deltaToEndTimestamp*-1+int64(ddcThreshold)) / float64(len(points.P)-1)))
 
@@ -255,12 +316,13 @@ func rearrangePoints(startDate int64, currentTimestamp int64, deltaToEndTimestam
 
 	storedOffsetToEnd := currentTimestamp - arrangedPoint
 	if storedOffsetToEnd < 0 {
-		panic("stored offset is negative")
+		return fmt.Errorf("error rearranging points: stored offset %d to the last timestamp %d is negative", storedOffsetToEnd, currentTimestamp)
 	}
 
 	setBPTimestamp(point, storedOffsetToEnd)
 
 	points.P = append(points.P, point)
+	return nil
 }
 
 func setT(point *pb.Point, delta int64) {
@@ -291,6 +353,13 @@ func safeLongToUInt(value int64) bool {
 }
 
 func calculateTimestamp(startDate int64, points []*pb.Point, ddcThreshold uint32) int64 {
+	calculatedPointDate, _ := calculateTimestampAndDelta(startDate, points, ddcThreshold)
+	return calculatedPointDate
+}
+
+// calculateTimestampAndDelta returns the decoded timestamp of the last point and
+// the delta the decoder uses for a following point without a delta.
+func calculateTimestampAndDelta(startDate int64, points []*pb.Point, ddcThreshold uint32) (int64, int64) {
 	lastDelta := int64(ddcThreshold)
 	calculatedPointDate := startDate
 
@@ -299,7 +368,7 @@ func calculateTimestamp(startDate int64, points []*pb.Point, ddcThreshold uint32
 		lastDelta = getTimestamp(p, lastDelta)
 		calculatedPointDate += lastDelta
 	}
-	return calculatedPointDate
+	return calculatedPointDate, lastDelta
 }
 
 func noDrift(drift int64, ddcThreshold uint32, timesSinceLastStoredDelta int32) bool {
@@ -0,0 +1,95 @@
+//go:build go1.18
+// +build go1.18
+
+package chronix
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"testing"
+)
+
+// fuzzPoints builds sorted points from 16 bytes per point.
+func fuzzPoints(data []byte) []Point {
+	points := make([]Point, 0, len(data)/16)
+	for ; len(data) >= 16; data = data[16:] {
+		points = append(points, Point{
+			Timestamp: int64(binary.LittleEndian.Uint64(data)),
+			Value:     math.Float64frombits(binary.LittleEndian.Uint64(data[8:])),
+		})
+	}
+	sort.Stable(byTimestamp(points))
+	return points
+}
+
+func fuzzSeed() [][]byte {
+	var seeds [][]byte
+	for _, points := range [][]Point{buildTestPoints(), buildJitteredPoints()[:100]} {
+		buf := make([]byte, 16*len(points))
+		for i, p := range points {
+			binary.LittleEndian.PutUint64(buf[16*i:], uint64(p.Timestamp))
+			binary.LittleEndian.PutUint64(buf[16*i+8:], math.Float64bits(p.Value))
+		}
+		seeds = append(seeds, buf)
+	}
+	return seeds
+}
+
+func FuzzEncodeDecode(f *testing.F) {
+	for _, seed := range fuzzSeed() {
+		f.Add(seed, uint32(0))
+		f.Add(seed, uint32(10))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, ddcThreshold uint32) {
+		points := fuzzPoints(data)
+		if len(points) == 0 {
+			return
+		}
+		start, end := points[0].Timestamp, points[len(points)-1].Timestamp
+		if start < 0 {
+			// Negative timestamps are not supported by the decoder.
+			return
+		}
+
+		encoded, err := encode(points, ddcThreshold)
+		if err != nil {
+			// Errors are fine, panics are not.
+			return
+		}
+		decoded, err := decode(encoded, start, end, start, end)
+		if err != nil {
+			t.Fatalf("Error decoding encoded points: %v", err)
+		}
+		if ddcThreshold > 0 {
+			// Timestamps may deviate and fall out of the range.
+			return
+		}
+
+		if len(decoded) != len(points) {
+			t.Fatalf("Unexpected number of points. Want %d, got %d", len(points), len(decoded))
+		}
+		for i := range points {
+			if decoded[i].Timestamp != points[i].Timestamp ||
+				math.Float64bits(decoded[i].Value) != math.Float64bits(points[i].Value) {
+				t.Fatalf("Unexpected point %d. Want %v, got %v", i, points[i], decoded[i])
+			}
+		}
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, seed := range fuzzSeed() {
+		encoded, err := encode(fuzzPoints(seed), 0)
+		if err != nil {
+			f.Fatal("Error encoding seed:", err)
+		}
+		f.Add(encoded)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Malformed data has to result in an error, not a panic.
+		decode(data, 0, math.MaxInt64, 0, math.MaxInt64)
+	})
+}
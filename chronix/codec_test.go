@@ -1,10 +1,17 @@
 package chronix
 
 import (
+	"bytes"
+	"compress/gzip"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"reflect"
 	"testing"
 	"encoding/base64"
+
+	"github.com/ChronixDB/chronix.go/chronix/pb"
+	"github.com/golang/protobuf/proto"
 )
 
 func buildTestPoints() []Point {
@@ -90,3 +97,185 @@ func TestDecodeWithDifferentPrefixes(t *testing.T) {
 		t.Fatalf("Points are not equal. Want:\n\n%v\n\nGot:\n\n%v", points1, points2)
 	}
 }
+
+func TestEncodeDecodePointsWithRange(t *testing.T) {
+	points := buildTestPoints()
+
+	buf, err := EncodePoints(points, 0)
+	if err != nil {
+		t.Fatal("Failed to encode points: ", err)
+	}
+
+	tsStart := points[0].Timestamp
+	tsEnd := points[len(points)-1].Timestamp
+	outPoints, err := DecodePoints(buf, tsStart, tsEnd, 20, 29)
+	if err != nil {
+		t.Fatal("Failed to decode points: ", err)
+	}
+	if !reflect.DeepEqual(points[5:15], outPoints) {
+		t.Fatalf("Unexpected points, want:\n\n%v\n\ngot:\n\n%v", points[5:15], outPoints)
+	}
+}
+
+// The Java Chronix server has to be able to read the chunks we produce, so the uncompressed
+// message has to match the reference chunk of the test points.
+func TestEncodePointsCompatibleWithServer(t *testing.T) {
+	points := buildTestPoints()
+
+	encoded, err := ioutil.ReadFile("fixtures/encoded.gz")
+	if err != nil {
+		t.Fatal("Failed to read test fixture: ", err)
+	}
+	buf, err := EncodePoints(points, 0)
+	if err != nil {
+		t.Fatal("Failed to encode points: ", err)
+	}
+
+	want := unmarshalPoints(encoded, t)
+	got := unmarshalPoints(buf, t)
+	if want.GetDdc() != got.GetDdc() || len(want.P) != len(got.P) {
+		t.Fatalf("Unexpected message, want:\n\n%v\n\ngot:\n\n%v", want, got)
+	}
+	for i := range want.P {
+		if !proto.Equal(want.P[i], got.P[i]) {
+			t.Fatalf("Unexpected point %d, want %v, got %v", i, want.P[i], got.P[i])
+		}
+	}
+}
+
+func unmarshalPoints(data []byte, t *testing.T) *pb.Points {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Failed to create gzip reader: ", err)
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("Failed to decompress points: ", err)
+	}
+	var points pb.Points
+	if err := proto.Unmarshal(buf, &points); err != nil {
+		t.Fatal("Failed to unmarshal points: ", err)
+	}
+	return &points
+}
+
+// Builds points with an interval of one second and a jitter of a few milliseconds.
+func buildJitteredPoints() []Point {
+	r := rand.New(rand.NewSource(42))
+	points := make([]Point, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		points = append(points, Point{
+			Timestamp: 1471517965000 + int64(i*1000) + int64(r.Intn(7)-3),
+			Value:     42,
+		})
+	}
+	return points
+}
+
+func TestEncodeWithDDCThreshold(t *testing.T) {
+	points := buildJitteredPoints()
+	tsStart := points[0].Timestamp
+	tsEnd := points[len(points)-1].Timestamp
+
+	exact, err := encode(points, 0)
+	if err != nil {
+		t.Fatal("Failed to encode points: ", err)
+	}
+
+	for _, threshold := range []uint32{10, 50} {
+		compacted, err := encode(points, threshold)
+		if err != nil {
+			t.Fatal("Failed to encode points: ", err)
+		}
+		if len(compacted) >= len(exact) {
+			t.Errorf("Expected threshold %d to reduce the chunk size of %d bytes, got %d bytes", threshold, len(exact), len(compacted))
+		}
+
+		outPoints, err := decode(compacted, tsStart, tsEnd, tsStart, tsEnd)
+		if err != nil {
+			t.Fatal("Failed to decode points: ", err)
+		}
+		if len(outPoints) != len(points) {
+			t.Fatalf("Expected %d points, got %d", len(points), len(outPoints))
+		}
+		for i, p := range outPoints {
+			deviation := p.Timestamp - points[i].Timestamp
+			if deviation < 0 {
+				deviation = -deviation
+			}
+			if deviation > int64(threshold) {
+				t.Fatalf("Timestamp %d deviates by %d ms, more than the threshold %d", i, deviation, threshold)
+			}
+			if p.Value != points[i].Value {
+				t.Fatalf("Unexpected value %d, want %v, got %v", i, points[i].Value, p.Value)
+			}
+		}
+		if outPoints[0].Timestamp != tsStart || outPoints[len(outPoints)-1].Timestamp != tsEnd {
+			t.Errorf("Expected exact first and last timestamps with threshold %d", threshold)
+		}
+	}
+}
+
+func compressPoints(points *pb.Points, t *testing.T) []byte {
+	buf, err := proto.Marshal(points)
+	if err != nil {
+		t.Fatal("Error marshalling points:", err)
+	}
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	if _, err := w.Write(buf); err != nil {
+		t.Fatal("Error compressing points:", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("Error closing gzip writer:", err)
+	}
+	return compressed.Bytes()
+}
+
+func TestDecodeRejectsMalformedData(t *testing.T) {
+	tests := [][]byte{
+		[]byte("not gzip"),
+		compressPoints(&pb.Points{P: []*pb.Point{{V: proto.Float64(1)}, {Tint: proto.Uint32(1), VIndex: proto.Uint32(2)}}}, t),
+		compressPoints(&pb.Points{P: []*pb.Point{{VIndex: proto.Uint32(0)}}}, t),
+		compressPoints(&pb.Points{P: []*pb.Point{{V: proto.Float64(1)}, {Tint: proto.Uint32(1)}}}, t),
+	}
+	for i, data := range tests {
+		if _, err := decode(data, 0, 10, 0, 10); err == nil {
+			t.Errorf("%d. Expected an error for malformed data", i)
+		}
+	}
+}
+
+func TestEncodeRejectsUnsortedPoints(t *testing.T) {
+	points := []Point{{Timestamp: 2}, {Timestamp: 1}}
+	if _, err := encode(points, 0); err == nil {
+		t.Fatal("Expected an error for unsorted points")
+	}
+}
+
+func TestEncodeDecodeEdgeCases(t *testing.T) {
+	negZero := math.Copysign(0, -1)
+	points := []Point{
+		{Timestamp: 0, Value: 0},
+		{Timestamp: 1, Value: negZero},
+		{Timestamp: 2, Value: math.NaN()},
+		{Timestamp: 2, Value: math.Inf(1)},
+		{Timestamp: 5, Value: 0},
+	}
+	data, err := encode(points, 0)
+	if err != nil {
+		t.Fatal("Error encoding points:", err)
+	}
+	got, err := decode(data, 0, 5, 0, 5)
+	if err != nil {
+		t.Fatal("Error decoding points:", err)
+	}
+	if len(got) != len(points) {
+		t.Fatalf("Unexpected points. Want %v, got %v", points, got)
+	}
+	for i := range points {
+		if got[i].Timestamp != points[i].Timestamp || math.Float64bits(got[i].Value) != math.Float64bits(points[i].Value) {
+			t.Errorf("Unexpected point %d. Want %v, got %v", i, points[i], got[i])
+		}
+	}
+}
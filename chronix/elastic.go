@@ -1,97 +1,313 @@
 package chronix
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"time"
 	"github.com/olivere/elastic"
 	"context"
-	"github.com/prometheus/common/log"
 )
 
+// A BulkItemError describes a document that Elasticsearch rejected in a bulk update.
+type BulkItemError struct {
+	// Index is the index the document should have been stored in.
+	Index string
+	// Status is the HTTP status code of the item.
+	Status int
+	// Type is the Elasticsearch error type, e.g. "mapper_parsing_exception".
+	Type string
+	// Reason is the human-readable error reason.
+	Reason string
+	// Document is the document that failed.
+	Document map[string]interface{}
+}
+
+// A BulkError is returned by the Elastic storage if documents of an update failed.
+// The other documents of the update have been indexed.
+type BulkError struct {
+	// Failed lists the documents that failed.
+	Failed []BulkItemError
+	// Total is the number of documents in the bulk request.
+	Total int
+}
+
+func (e *BulkError) Error() string {
+	msg := fmt.Sprintf("%d of %d documents failed to index", len(e.Failed), e.Total)
+	if len(e.Failed) > 0 {
+		f := e.Failed[0]
+		msg += fmt.Sprintf(", first error: index %s, status %d, %s: %s", f.Index, f.Status, f.Type, f.Reason)
+	}
+	return msg
+}
+
+// Documents returns the failed documents. They can be passed to Update again to
+// retry only the failed items.
+func (e *BulkError) Documents() []map[string]interface{} {
+	docs := make([]map[string]interface{}, 0, len(e.Failed))
+	for _, f := range e.Failed {
+		if f.Document != nil {
+			docs = append(docs, f.Document)
+		}
+	}
+	return docs
+}
+
+// elasticQuerySize is the maximum number of documents returned by a query.
+const elasticQuerySize = 10000
+
+// A TruncatedResultError is returned by the Elastic storage if a query matches more
+// documents than a single search returns. IterateSeries reads all of them.
+type TruncatedResultError struct {
+	// Total is the number of matching documents.
+	Total int64
+	// Returned is the number of documents a search returns.
+	Returned int64
+}
+
+func (e *TruncatedResultError) Error() string {
+	return fmt.Sprintf("query matches %d documents, but only %d can be returned at once; iterate the series instead", e.Total, e.Returned)
+}
+
+// elasticQueryResponse models a Solr select response built from Elasticsearch hits.
+type elasticQueryResponse struct {
+	Response struct {
+		NumFound int64              `json:"numFound"`
+		Start    int64              `json:"start"`
+		Docs     []*json.RawMessage `json:"docs"`
+	} `json:"response"`
+}
+
 type elasticClient struct {
 	elastic *elastic.Client
+	index   ElasticIndex
+	retry   RetryPolicy
+}
+
+// ElasticOptions configures the Elastic storage.
+type ElasticOptions struct {
+	// WithIndex creates the index (or index template) if it does not exist.
+	WithIndex bool
+	// DeleteIfExists deletes an existing index (or index template) before creating it.
+	// It is only used together with WithIndex.
+	DeleteIfExists bool
+	// DisableSniff disables sniffing for the nodes of the cluster, which is enabled by default.
+	DisableSniff bool
+	// Index configures the index. Empty values are set to the ones of DefaultElasticIndex.
+	Index ElasticIndex
+	// Retry configures how failed updates are retried.
+	Retry RetryPolicy
+}
+
+// ErrFunctionsNotSupported is returned for queries with Chronix functions, which
+// are only evaluated by the Chronix server.
+var ErrFunctionsNotSupported = errors.New("chronix functions are not supported by Elasticsearch")
+
+// ErrNotAcknowledged is the cause of an IndexSetupError if Elasticsearch did not
+// acknowledge the creation of an index or index template.
+var ErrNotAcknowledged = errors.New("request was not acknowledged")
+
+// An IndexSetupError is returned if the index or index template could not be set up.
+type IndexSetupError struct {
+	// Index is the name of the index or index template.
+	Index string
+	// Op is the failed operation, e.g. "create index".
+	Op string
+	// Err is the cause of the error.
+	Err error
+}
+
+func (e *IndexSetupError) Error() string {
+	return fmt.Sprintf("error setting up index '%s': %s: %v", e.Index, e.Op, e.Err)
+}
+
+// Unwrap returns the cause of the error, e.g. for errors.Is(err, ErrNotAcknowledged).
+func (e *IndexSetupError) Unwrap() error {
+	return e.Err
 }
 
 // Only for test purposes
-func NewElasticTestStorage(url *string) StorageClient {
+func NewElasticTestStorage(url *string) (StorageClient, error) {
 	client, err := elastic.NewClient(elastic.SetURL(*url), elastic.SetHealthcheck(false), elastic.SetSniff(false))
 	if err != nil {
-		log.Fatal(fmt.Errorf("error creating elasticserach client: %v", err))
-		return nil
+		return nil, fmt.Errorf("error creating elasticsearch client: %v", err)
 	}
 
 	return &elasticClient{
 		elastic: client,
-	}
+		index:   DefaultElasticIndex,
+	}, nil
 }
 
 // NewElasticStorage creates a new Elastic client.
-func NewElasticStorage(url *string, withIndex *bool, deleteIfExists *bool, sniffElasticNodes *bool) StorageClient {
+func NewElasticStorage(url *string, withIndex *bool, deleteIfExists *bool, sniffElasticNodes *bool) (StorageClient, error) {
+	return NewElasticStorageWithRetry(url, withIndex, deleteIfExists, sniffElasticNodes, RetryPolicy{})
+}
 
-	sniff := true
+// NewElasticStorageWithRetry creates a new Elastic client that retries failed updates
+// according to the given policy. Only the documents that failed are sent again.
+func NewElasticStorageWithRetry(url *string, withIndex *bool, deleteIfExists *bool, sniffElasticNodes *bool, retry RetryPolicy) (StorageClient, error) {
+	opts := ElasticOptions{
+		Retry: retry,
+	}
+	if withIndex != nil {
+		opts.WithIndex = *withIndex
+	}
+	if deleteIfExists != nil {
+		opts.DeleteIfExists = *deleteIfExists
+	}
 	if sniffElasticNodes != nil {
-		sniff = *sniffElasticNodes
+		opts.DisableSniff = !*sniffElasticNodes
 	}
+	return NewElasticStorageWithOptions(*url, opts)
+}
 
-	client, err := elastic.NewClient(elastic.SetURL(*url), elastic.SetSniff(sniff))
+// NewElasticStorageWithOptions creates a new Elastic client with the given options.
+// If the index cannot be set up, an *IndexSetupError is returned.
+func NewElasticStorageWithOptions(url string, opts ElasticOptions) (StorageClient, error) {
+	client, err := elastic.NewClient(elastic.SetURL(url), elastic.SetSniff(!opts.DisableSniff))
 	if err != nil {
-		log.Fatal(fmt.Errorf("error creating elasticserach client: %v", err))
-		return nil
+		return nil, fmt.Errorf("error creating elasticsearch client: %v", err)
 	}
 
-	if *withIndex {
-		configureIndex(client, deleteIfExists)
+	index := opts.Index.withDefaults()
+	if opts.WithIndex {
+		if err := configureIndex(client, index, opts.DeleteIfExists); err != nil {
+			return nil, err
+		}
 	}
 
 	return &elasticClient{
 		elastic: client,
+		index:   index,
+		retry:   opts.Retry,
+	}, nil
+}
+
+// An ElasticOption configures an Elastic client created by NewElastic.
+type ElasticOption func(*ElasticOptions)
+
+// NewElastic creates a new Elastic client with the given options. Without options,
+// the client sniffs for the nodes of the cluster, uses the DefaultElasticIndex and
+// does not create it. If the index cannot be set up, an *IndexSetupError is returned.
+func NewElastic(url string, opts ...ElasticOption) (StorageClient, error) {
+	var o ElasticOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return NewElasticStorageWithOptions(url, o)
+}
+
+// WithElasticIndex configures the index. Empty values are set to the ones of DefaultElasticIndex.
+func WithElasticIndex(index ElasticIndex) ElasticOption {
+	return func(o *ElasticOptions) {
+		o.Index = index
+	}
+}
+
+// WithElasticIndexSetup creates the index (or index template) if it does not exist.
+// An existing one is deleted first if deleteIfExists is set.
+func WithElasticIndexSetup(deleteIfExists bool) ElasticOption {
+	return func(o *ElasticOptions) {
+		o.WithIndex = true
+		o.DeleteIfExists = deleteIfExists
 	}
 }
 
-func configureIndex(client *elastic.Client, deleteIfExists *bool) {
+// WithElasticSniff enables or disables sniffing for the nodes of the cluster.
+func WithElasticSniff(sniff bool) ElasticOption {
+	return func(o *ElasticOptions) {
+		o.DisableSniff = !sniff
+	}
+}
+
+// WithElasticRetry retries failed updates according to the given policy.
+func WithElasticRetry(retry RetryPolicy) ElasticOption {
+	return func(o *ElasticOptions) {
+		o.Retry = retry
+	}
+}
+
+func configureIndex(client *elastic.Client, index ElasticIndex, deleteIfExists bool) error {
+	if index.UseTemplate || index.hasTimePattern() {
+		return configureTemplate(client, index, deleteIfExists)
+	}
+
 	//Delete if exists
-	exists, err := client.IndexExists("chronix").Do(context.Background())
+	exists, err := client.IndexExists(index.Name).Do(context.Background())
 	if err != nil {
-		log.Fatal(fmt.Errorf("error checking if index 'chronix' exists: %v", err))
+		return &IndexSetupError{Index: index.Name, Op: "check if index exists", Err: err}
 	}
 
 	//if the index does not exist or we should delete the index
-	if !exists || *deleteIfExists {
+	if !exists || deleteIfExists {
 
-		if *deleteIfExists {
-			log.Info("Delete index")
-			client.DeleteIndex("chronix").Do(context.Background())
+		if exists && deleteIfExists {
+			deleteIndex, err := client.DeleteIndex(index.Name).Do(context.Background())
+			if err != nil {
+				return &IndexSetupError{Index: index.Name, Op: "delete index", Err: err}
+			}
+			if !deleteIndex.Acknowledged {
+				return &IndexSetupError{Index: index.Name, Op: "delete index", Err: ErrNotAcknowledged}
+			}
 		}
 
-		log.Info("Create new index")
-
-		mapping :=
-			`{"settings":{"number_of_shards":1,"number_of_replicas":0}, "mappings":{	"doc":{
-			"properties":{
-				"data":{"type":"binary", "doc_values": false},
-				"start":{"type":"date", "format": "epoch_millis"},
-				"end":{"type":"date", "format": "epoch_millis"},
-				"name":{"type":"text"},
-				"type":{"type":"text"}
-			}}}}`
-
-		createIndex, err := client.CreateIndex("chronix").Body(mapping).Do(context.Background())
+		mapping, err := index.indexBody()
 		if err != nil {
-			// Handle error
-			log.Fatal(err)
+			return &IndexSetupError{Index: index.Name, Op: "marshal mapping", Err: err}
 		}
 
+		createIndex, err := client.CreateIndex(index.Name).Body(mapping).Do(context.Background())
+		if err != nil {
+			return &IndexSetupError{Index: index.Name, Op: "create index", Err: err}
+		}
 		if !createIndex.Acknowledged {
-			// Not acknowledged
+			return &IndexSetupError{Index: index.Name, Op: "create index", Err: ErrNotAcknowledged}
 		}
 	}
+	return nil
+}
+
+func configureTemplate(client *elastic.Client, index ElasticIndex, deleteIfExists bool) error {
+	name := index.templateName()
+
+	exists, err := client.IndexTemplateExists(name).Do(context.Background())
+	if err != nil {
+		return &IndexSetupError{Index: name, Op: "check if index template exists", Err: err}
+	}
+	if exists && !deleteIfExists {
+		return nil
+	}
+
+	template, err := index.templateBody()
+	if err != nil {
+		return &IndexSetupError{Index: name, Op: "marshal index template", Err: err}
+	}
+
+	putTemplate, err := client.IndexPutTemplate(name).BodyString(template).Do(context.Background())
+	if err != nil {
+		return &IndexSetupError{Index: name, Op: "put index template", Err: err}
+	}
+	if !putTemplate.Acknowledged {
+		return &IndexSetupError{Index: name, Op: "put index template", Err: ErrNotAcknowledged}
+	}
+	return nil
 }
 
 // Update implements StorageClient.
 func (c *elasticClient) Update(data []map[string]interface{}, commit bool, commitWithin time.Duration) error {
+	return c.UpdateContext(context.Background(), data, commit, commitWithin)
+}
 
-	var bulk = c.elastic.Bulk()
+// UpdateContext implements StorageClient.
+func (c *elasticClient) UpdateContext(ctx context.Context, data []map[string]interface{}, commit bool, commitWithin time.Duration) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	requests := make([]elastic.BulkableRequest, 0, len(data))
 
 	//loop over the documents
 	for k := range data {
@@ -101,21 +317,249 @@ func (c *elasticClient) Update(data []map[string]interface{}, commit bool, commi
 		}
 
 		req := elastic.NewBulkIndexRequest().
-			Index("chronix").
-			Type("doc").
+			Index(c.index.name(startOf(data[k]))).
+			Type(c.index.Type).
 			Doc(string(buf))
 
-		bulk.Add(req)
+		// With an explicit id a retried document replaces an already indexed copy.
+		if c.retry.enabled() {
+			id, ok := data[k]["id"].(string)
+			if !ok {
+				if id, err = newDocumentID(); err != nil {
+					return err
+				}
+			}
+			req.Id(id)
+		}
 
+		requests = append(requests, req)
 	}
-	bulk.Do(context.Background())
-	return nil
+
+	// failed collects the documents that failed permanently in earlier attempts.
+	var failed []BulkItemError
+	docs := data
+	for attempt := 1; ; attempt++ {
+		retryable, err := c.bulk(ctx, requests, docs)
+		if err == nil || len(retryable) == 0 || attempt >= c.retry.attempts() {
+			return bulkResult(err, failed, docs, len(data))
+		}
+		if werr := wait(ctx, c.retry.backoff(attempt)); werr != nil {
+			return bulkResult(err, failed, docs, len(data))
+		}
+		if bulkErr, ok := err.(*BulkError); ok {
+			for _, f := range bulkErr.Failed {
+				if f.Document == nil || !c.retry.retryable(f.Status) {
+					failed = append(failed, f)
+				}
+			}
+		}
+
+		// Only send the documents that failed again.
+		var retryRequests []elastic.BulkableRequest
+		var retryDocs []map[string]interface{}
+		for _, i := range retryable {
+			retryRequests = append(retryRequests, requests[i])
+			retryDocs = append(retryDocs, docs[i])
+		}
+		requests, docs = retryRequests, retryDocs
+	}
+}
+
+// bulkResult returns the error of an update from the error of its last attempt and
+// the documents that failed permanently in earlier attempts. If the last attempt
+// failed as a whole, all of its documents are reported as failed.
+func bulkResult(err error, failed []BulkItemError, docs []map[string]interface{}, total int) error {
+	switch e := err.(type) {
+	case nil:
+		if len(failed) == 0 {
+			return nil
+		}
+	case *BulkError:
+		failed = append(failed, e.Failed...)
+	default:
+		if len(failed) == 0 {
+			return err
+		}
+		for _, doc := range docs {
+			failed = append(failed, BulkItemError{Reason: err.Error(), Document: doc})
+		}
+	}
+	return &BulkError{Failed: failed, Total: total}
+}
+
+// bulk sends the requests in one bulk request. It returns the indices of the
+// requests that failed and can be retried.
+func (c *elasticClient) bulk(ctx context.Context, requests []elastic.BulkableRequest, docs []map[string]interface{}) ([]int, error) {
+	resp, err := c.elastic.Bulk().Add(requests...).Do(ctx)
+	if err != nil {
+		all := make([]int, len(requests))
+		for i := range all {
+			all[i] = i
+		}
+		if e, ok := err.(*elastic.Error); ok {
+			if c.retry.retryable(e.Status) {
+				return all, fmt.Errorf("error sending bulk request: %v", err)
+			}
+			return nil, fmt.Errorf("error sending bulk request: %v", err)
+		}
+		// Network failures can be retried, the documents have ids if retries are enabled.
+		if ctx.Err() == nil {
+			return all, fmt.Errorf("error sending bulk request: %v", err)
+		}
+		return nil, fmt.Errorf("error sending bulk request: %v", err)
+	}
+
+	var (
+		retryable []int
+		bulkErr   = &BulkError{Total: len(requests)}
+	)
+	for i, item := range resp.Items {
+		for _, result := range item {
+			if result.Error == nil && result.Status >= 200 && result.Status <= 299 {
+				continue
+			}
+			itemErr := BulkItemError{
+				Index:  result.Index,
+				Status: result.Status,
+			}
+			if result.Error != nil {
+				itemErr.Type = result.Error.Type
+				itemErr.Reason = result.Error.Reason
+			}
+			if i < len(docs) {
+				itemErr.Document = docs[i]
+				if c.retry.retryable(result.Status) {
+					retryable = append(retryable, i)
+				}
+			}
+			bulkErr.Failed = append(bulkErr.Failed, itemErr)
+		}
+	}
+	if len(bulkErr.Failed) > 0 {
+		return retryable, bulkErr
+	}
+	return nil, nil
 }
 
+// Query implements StorageClient.
 func (c *elasticClient) Query(q, cj, fl string) ([]byte, error) {
-	return nil, fmt.Errorf("not yet implemented")
+	return c.QueryContext(context.Background(), q, cj, fl)
+}
+
+// QueryContext implements StorageClient. The query is translated into an Elasticsearch
+// search (see translateQuery) and the hits are returned in the shape of a Solr response.
+// The join and field list parameters are not supported and ignored.
+func (c *elasticClient) QueryContext(ctx context.Context, q, cj, fl string) ([]byte, error) {
+	return c.QueryWithParams(ctx, QueryParams{Q: q, CJ: cj, FL: fl})
+}
+
+// QueryWithParams implements StorageClient. Chronix functions are not supported
+// by Elasticsearch. If the query matches more documents than a search returns, a
+// *TruncatedResultError is returned.
+func (c *elasticClient) QueryWithParams(ctx context.Context, params QueryParams) ([]byte, error) {
+	if params.CF != "" {
+		return nil, ErrFunctionsNotSupported
+	}
+	query, err := translateQuery(params.Q, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("error translating query: %v", err)
+	}
+
+	result, err := c.elastic.Search(c.index.pattern()).
+		Query(query).
+		Size(elasticQuerySize).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error sending search request: %v", err)
+	}
+
+	var resp elasticQueryResponse
+	resp.Response.Docs = []*json.RawMessage{}
+	if result.Hits != nil {
+		if result.Hits.TotalHits > int64(len(result.Hits.Hits)) {
+			return nil, &TruncatedResultError{Total: result.Hits.TotalHits, Returned: int64(len(result.Hits.Hits))}
+		}
+		resp.Response.NumFound = result.Hits.TotalHits
+		for _, hit := range result.Hits.Hits {
+			if hit.Source != nil {
+				resp.Response.Docs = append(resp.Response.Docs, hit.Source)
+			}
+		}
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling JSON: %v", err)
+	}
+	return body, nil
+}
+
+// elasticScrollKeepAlive is the time Elasticsearch keeps the scroll context of a
+// paged query between two pages.
+const elasticScrollKeepAlive = "5m"
+
+// QueryPage implements StorageClient. It pages through the results using the
+// scroll API; the cursor is the scroll id.
+func (c *elasticClient) QueryPage(ctx context.Context, params QueryParams, cursor string, rows int) (*ResultPage, error) {
+	if params.CF != "" {
+		return nil, ErrFunctionsNotSupported
+	}
+
+	scroll := c.elastic.Scroll(c.index.pattern()).
+		KeepAlive(elasticScrollKeepAlive).
+		Size(rows)
+	if cursor == "" {
+		query, err := translateQuery(params.Q, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("error translating query: %v", err)
+		}
+		scroll = scroll.Query(query)
+	} else {
+		scroll = scroll.ScrollId(cursor)
+	}
+
+	result, err := scroll.Do(ctx)
+	if err == io.EOF {
+		if result != nil && result.ScrollId != "" {
+			// The scroll context expires anyway, so errors are not reported.
+			c.elastic.ClearScroll(result.ScrollId).Do(ctx)
+		}
+		return &ResultPage{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error sending scroll request: %v", err)
+	}
+
+	page := &ResultPage{Cursor: result.ScrollId}
+	if result.Hits != nil {
+		for _, hit := range result.Hits.Hits {
+			if hit.Source == nil {
+				continue
+			}
+			var doc map[string]interface{}
+			dec := json.NewDecoder(bytes.NewReader(*hit.Source))
+			dec.UseNumber()
+			if err := dec.Decode(&doc); err != nil {
+				return nil, fmt.Errorf("error unmarshalling hit: %v", err)
+			}
+			page.Docs = append(page.Docs, doc)
+		}
+	}
+	return page, nil
+}
+
+// CloseCursor implements StorageClient. It clears the scroll.
+func (c *elasticClient) CloseCursor(ctx context.Context, cursor string) error {
+	if _, err := c.elastic.ClearScroll(cursor).Do(ctx); err != nil {
+		return fmt.Errorf("error clearing scroll: %v", err)
+	}
+	return nil
 }
 
 func (c *elasticClient) NeedPostfixOnDynamicField() bool {
 	return false
 }
+
+func (c *elasticClient) DynamicFieldName(name string, value interface{}) string {
+	return name
+}
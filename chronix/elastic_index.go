@@ -0,0 +1,163 @@
+package chronix
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// ElasticIndex configures the Elasticsearch index the chunks are stored in.
+type ElasticIndex struct {
+	// Name is the name of the index. It may contain a time pattern in braces using
+	// the layout of the time package, e.g. "chronix-{2006.01}" for monthly indices
+	// such as "chronix-2026.10". Chunks are stored in the index of their start
+	// timestamp (in UTC) and queries search all indices matching the pattern.
+	Name string
+	// Type is the document type.
+	Type string
+	// Shards is the number of primary shards of new indices.
+	Shards int
+	// Replicas is the number of replicas of new indices.
+	Replicas int
+	// AttributeMappings maps attribute names to Elasticsearch field types, e.g.
+	// "host" to "keyword". Attributes without a mapping are mapped dynamically.
+	AttributeMappings map[string]string
+	// UseTemplate creates an index template instead of an index, so that new
+	// indices matching the name get the mapping automatically. It is always used
+	// if the name contains a time pattern.
+	UseTemplate bool
+}
+
+// DefaultElasticIndex is the index used if no other index is configured.
+var DefaultElasticIndex = ElasticIndex{
+	Name:   "chronix",
+	Type:   "doc",
+	Shards: 1,
+}
+
+// withDefaults returns the index with the defaults set for all empty values.
+func (i ElasticIndex) withDefaults() ElasticIndex {
+	if i.Name == "" {
+		i.Name = DefaultElasticIndex.Name
+	}
+	if i.Type == "" {
+		i.Type = DefaultElasticIndex.Type
+	}
+	if i.Shards <= 0 {
+		i.Shards = DefaultElasticIndex.Shards
+	}
+	return i
+}
+
+// hasTimePattern reports whether the index name contains a time pattern.
+func (i ElasticIndex) hasTimePattern() bool {
+	open := strings.Index(i.Name, "{")
+	return open >= 0 && strings.Index(i.Name[open:], "}") > 0
+}
+
+// name returns the name of the index for a chunk starting at the given time
+// in milliseconds.
+func (i ElasticIndex) name(start int64) string {
+	if !i.hasTimePattern() {
+		return i.Name
+	}
+	t := time.Unix(0, start*int64(time.Millisecond)).UTC()
+	return i.replacePattern(func(layout string) string {
+		return t.Format(layout)
+	})
+}
+
+// pattern returns the wildcard pattern matching all indices of the index.
+func (i ElasticIndex) pattern() string {
+	if !i.hasTimePattern() {
+		return i.Name
+	}
+	return i.replacePattern(func(string) string {
+		return "*"
+	})
+}
+
+// templateName returns the name of the index template.
+func (i ElasticIndex) templateName() string {
+	if !i.hasTimePattern() {
+		return i.Name
+	}
+	name := i.replacePattern(func(string) string {
+		return ""
+	})
+	return strings.Trim(name, "-_.")
+}
+
+func (i ElasticIndex) replacePattern(replace func(layout string) string) string {
+	var result []string
+	rest := i.Name
+	for {
+		open := strings.Index(rest, "{")
+		if open < 0 {
+			break
+		}
+		end := strings.Index(rest[open:], "}")
+		if end < 0 {
+			break
+		}
+		result = append(result, rest[:open], replace(rest[open+1:open+end]))
+		rest = rest[open+end+1:]
+	}
+	return strings.Join(append(result, rest), "")
+}
+
+// mappings returns the settings and mappings of the index.
+func (i ElasticIndex) mappings() map[string]interface{} {
+	properties := map[string]interface{}{
+		"data":  map[string]interface{}{"type": "binary", "doc_values": false},
+		"start": map[string]interface{}{"type": "date", "format": "epoch_millis"},
+		"end":   map[string]interface{}{"type": "date", "format": "epoch_millis"},
+		"name":  map[string]interface{}{"type": "text"},
+		"type":  map[string]interface{}{"type": "text"},
+	}
+	for attribute, fieldType := range i.AttributeMappings {
+		properties[attribute] = map[string]interface{}{"type": fieldType}
+	}
+
+	return map[string]interface{}{
+		"settings": map[string]interface{}{
+			"number_of_shards":   i.Shards,
+			"number_of_replicas": i.Replicas,
+		},
+		"mappings": map[string]interface{}{
+			i.Type: map[string]interface{}{
+				"properties": properties,
+			},
+		},
+	}
+}
+
+// indexBody returns the body to create the index.
+func (i ElasticIndex) indexBody() (string, error) {
+	buf, err := json.Marshal(i.mappings())
+	return string(buf), err
+}
+
+// templateBody returns the body to create the index template.
+func (i ElasticIndex) templateBody() (string, error) {
+	body := i.mappings()
+	body["index_patterns"] = []string{i.pattern()}
+	buf, err := json.Marshal(body)
+	return string(buf), err
+}
+
+// startOf returns the start timestamp of a document or 0 if it has none.
+func startOf(doc map[string]interface{}) int64 {
+	switch v := doc["start"].(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case float64:
+		return int64(v)
+	case json.Number:
+		n, _ := v.Int64()
+		return n
+	}
+	return 0
+}
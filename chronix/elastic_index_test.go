@@ -0,0 +1,144 @@
+package chronix
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestElasticIndexNames(t *testing.T) {
+	// 2016-08-18T10:59:25Z
+	start := int64(1471517965000)
+
+	for _, tc := range []struct {
+		index        ElasticIndex
+		name         string
+		pattern      string
+		templateName string
+	}{
+		{DefaultElasticIndex, "chronix", "chronix", "chronix"},
+		{ElasticIndex{Name: "chronix-{2006.01}"}, "chronix-2016.08", "chronix-*", "chronix"},
+		{ElasticIndex{Name: "tenant-{2006}-{01.02}"}, "tenant-2016-08.18", "tenant-*-*", "tenant"},
+	} {
+		if got := tc.index.name(start); got != tc.name {
+			t.Errorf("Unexpected name of %s; want %s, got %s", tc.index.Name, tc.name, got)
+		}
+		if got := tc.index.pattern(); got != tc.pattern {
+			t.Errorf("Unexpected pattern of %s; want %s, got %s", tc.index.Name, tc.pattern, got)
+		}
+		if got := tc.index.templateName(); got != tc.templateName {
+			t.Errorf("Unexpected template name of %s; want %s, got %s", tc.index.Name, tc.templateName, got)
+		}
+	}
+}
+
+func TestElasticIndexDefaults(t *testing.T) {
+	index := ElasticIndex{Name: "tenant"}.withDefaults()
+	want := ElasticIndex{Name: "tenant", Type: "doc", Shards: 1}
+	if !reflect.DeepEqual(index, want) {
+		t.Fatalf("Unexpected index; want %+v, got %+v", want, index)
+	}
+}
+
+func TestElasticIndexTemplate(t *testing.T) {
+	index := ElasticIndex{
+		Name:              "chronix-{2006.01}",
+		Shards:            3,
+		Replicas:          2,
+		AttributeMappings: map[string]string{"host": "keyword"},
+	}.withDefaults()
+
+	var requests []string
+	var template map[string]interface{}
+	var bulk []interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal("Error reading request body:", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method + " " + r.URL.Path {
+		case "HEAD /_template/chronix":
+			w.WriteHeader(http.StatusNotFound)
+		case "PUT /_template/chronix":
+			if err := json.Unmarshal(body, &template); err != nil {
+				t.Fatal("Error unmarshalling template:", err)
+			}
+			w.Write([]byte(`{"acknowledged":true}`))
+		case "POST /_bulk":
+			bulk = parseReceived(body, t)
+			w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	storage, err := NewElasticStorageWithOptions(server.URL, ElasticOptions{WithIndex: true, DisableSniff: true, Index: index})
+	if err != nil {
+		t.Fatal("Error creating Elastic storage:", err)
+	}
+
+	var want map[string]interface{}
+	if err := json.Unmarshal([]byte(`{
+		"index_patterns": ["chronix-*"],
+		"settings": {"number_of_shards": 3, "number_of_replicas": 2},
+		"mappings": {"doc": {"properties": {
+			"data": {"type": "binary", "doc_values": false},
+			"start": {"type": "date", "format": "epoch_millis"},
+			"end": {"type": "date", "format": "epoch_millis"},
+			"name": {"type": "text"},
+			"type": {"type": "text"},
+			"host": {"type": "keyword"}
+		}}}
+	}`), &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(template, want) {
+		t.Fatalf("Unexpected template. Want:\n\n%v\n\nGot:\n\n%v\n\nRequests: %v", want, template, requests)
+	}
+
+	if err := storage.Update([]map[string]interface{}{{"name": "test", "start": int64(1471517965000)}}, false, 0); err != nil {
+		t.Fatal("Error updating:", err)
+	}
+	action := bulk[0].(map[string]interface{})["index"].(map[string]interface{})
+	if action["_index"] != "chronix-2016.08" {
+		t.Errorf("Expected the document to be stored in the monthly index, got %v", action["_index"])
+	}
+}
+
+func TestElasticIndexSetupErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method + " " + r.URL.Path {
+		case "HEAD /chronix":
+			w.WriteHeader(http.StatusNotFound)
+		case "PUT /chronix":
+			w.Write([]byte(`{"acknowledged":false}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	storage, err := NewElasticStorageWithOptions(server.URL, ElasticOptions{WithIndex: true, DisableSniff: true})
+	if storage != nil {
+		t.Error("Expected no storage")
+	}
+	setupErr, ok := err.(*IndexSetupError)
+	if !ok {
+		t.Fatalf("Expected an *IndexSetupError, got %v", err)
+	}
+	if setupErr.Index != "chronix" || setupErr.Op != "create index" || setupErr.Err != ErrNotAcknowledged {
+		t.Errorf("Unexpected error: %v", setupErr)
+	}
+	if !errors.Is(err, ErrNotAcknowledged) {
+		t.Errorf("Expected the error to wrap ErrNotAcknowledged, got %v", err)
+	}
+}
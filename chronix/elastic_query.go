@@ -0,0 +1,170 @@
+package chronix
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic"
+)
+
+// translateQuery translates a Chronix query such as
+// "name:(testmetric) AND host:(a OR b) AND -dc:eu AND start:1471517965000 AND end:NOW"
+// into an Elasticsearch query. The clauses have to be joined by AND, clauses
+// starting with "-" exclude the matching chunks. The constraints on start and
+// end select all chunks that overlap the time range.
+func translateQuery(q string, now time.Time) (elastic.Query, error) {
+	q = strings.TrimSpace(q)
+	if q == "" || q == "*:*" {
+		return elastic.NewMatchAllQuery(), nil
+	}
+
+	clauses, err := splitOutside(q, " AND ")
+	if err != nil {
+		return nil, err
+	}
+
+	query := elastic.NewBoolQuery()
+	for _, clause := range clauses {
+		clause = strings.TrimSpace(clause)
+		if clause == "*:*" {
+			continue
+		}
+		i := strings.Index(clause, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid query clause '%s'", clause)
+		}
+		field, value := clause[:i], strings.TrimSpace(clause[i+1:])
+
+		switch field {
+		case "start":
+			ts, err := parseQueryTimestamp(value, now)
+			if err != nil {
+				return nil, err
+			}
+			query.Filter(elastic.NewRangeQuery("end").Gte(ts))
+		case "end":
+			ts, err := parseQueryTimestamp(value, now)
+			if err != nil {
+				return nil, err
+			}
+			query.Filter(elastic.NewRangeQuery("start").Lte(ts))
+		default:
+			negate := strings.HasPrefix(field, "-")
+			fq, err := translateFieldQuery(strings.TrimPrefix(field, "-"), value)
+			if err != nil {
+				return nil, err
+			}
+			if negate {
+				query.MustNot(fq)
+			} else {
+				query.Filter(fq)
+			}
+		}
+	}
+	return query, nil
+}
+
+// translateFieldQuery translates the value of a field clause, which is either a
+// single term or a list of terms joined by OR in parentheses.
+func translateFieldQuery(field, value string) (elastic.Query, error) {
+	values := []string{value}
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		var err error
+		values, err = splitOutside(value[1:len(value)-1], " OR ")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var queries []elastic.Query
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "":
+			return nil, fmt.Errorf("empty value for field '%s'", field)
+		case v == "*":
+			queries = append(queries, elastic.NewExistsQuery(field))
+		case strings.HasPrefix(v, `"`) && strings.HasSuffix(v, `"`) && len(v) > 1:
+			queries = append(queries, elastic.NewMatchPhraseQuery(field, unescapeQueryValue(v[1:len(v)-1])))
+		case strings.HasPrefix(v, "/") && strings.HasSuffix(v, "/") && len(v) > 1:
+			regex := strings.Replace(v[1:len(v)-1], `\/`, "/", -1)
+			queries = append(queries, elastic.NewRegexpQuery(field, regex))
+		case strings.HasSuffix(v, "*") && !strings.HasSuffix(v, `\*`):
+			queries = append(queries, elastic.NewPrefixQuery(field, unescapeQueryValue(strings.TrimSuffix(v, "*"))))
+		default:
+			queries = append(queries, elastic.NewMatchPhraseQuery(field, unescapeQueryValue(v)))
+		}
+	}
+
+	if len(queries) == 1 {
+		return queries[0], nil
+	}
+	return elastic.NewBoolQuery().Should(queries...).MinimumNumberShouldMatch(1), nil
+}
+
+// parseQueryTimestamp parses a timestamp in milliseconds or NOW.
+func parseQueryTimestamp(value string, now time.Time) (int64, error) {
+	if value == "NOW" {
+		return now.UnixNano() / 1e6, nil
+	}
+	ts, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp '%s': %v", value, err)
+	}
+	return ts, nil
+}
+
+// unescapeQueryValue removes the backslashes escaping characters in a value.
+func unescapeQueryValue(v string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+1 < len(v) {
+			i++
+		}
+		buf.WriteByte(v[i])
+	}
+	return buf.String()
+}
+
+// splitOutside splits s at sep, ignoring separators within quotes, regular
+// expressions or parentheses and escaped characters.
+func splitOutside(s, sep string) ([]string, error) {
+	var (
+		parts   []string
+		depth   int
+		quote   byte
+		lastCut int
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"':
+			quote = c
+		case c == '/' && (i == 0 || strings.IndexByte(":( ", s[i-1]) >= 0):
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in '%s'", s)
+			}
+		case depth == 0 && strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[lastCut:i])
+			lastCut = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+	if depth != 0 || quote != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses or quotes in '%s'", s)
+	}
+	return append(parts, s[lastCut:]), nil
+}
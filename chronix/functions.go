@@ -0,0 +1,198 @@
+package chronix
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Function is a Chronix function that is evaluated on the server for every
+// (joined) series of a query result, see QueryBuilder.Functions. Use the
+// constructors such as Max or Percentile to create functions; their parameters
+// are validated when the query is built.
+type Function struct {
+	name string
+	args []string
+	err  error
+}
+
+// Min returns the minimum value of a series.
+func Min() Function { return Function{name: "min"} }
+
+// Max returns the maximum value of a series.
+func Max() Function { return Function{name: "max"} }
+
+// Avg returns the average value of a series.
+func Avg() Function { return Function{name: "avg"} }
+
+// Percentile returns the p-percentile of the values of a series, with p in (0, 1].
+func Percentile(p float64) Function {
+	f := Function{name: "p", args: []string{strconv.FormatFloat(p, 'g', -1, 64)}}
+	if !(p > 0 && p <= 1) {
+		f.err = fmt.Errorf("percentile %v is not in (0, 1]", p)
+	}
+	return f
+}
+
+// Derivative replaces the values of a series by their derivative.
+func Derivative() Function { return Function{name: "derivative"} }
+
+// Trend matches series whose values have a positive linear trend.
+func Trend() Function { return Function{name: "trend"} }
+
+// Outlier matches series with values above the upper outlier fence of their
+// interquartile range.
+func Outlier() Function { return Function{name: "outlier"} }
+
+// Frequency matches series in which the number of points within windows of the
+// given size in minutes increases by more than the threshold between two windows.
+func Frequency(windowMinutes, threshold int) Function {
+	f := Function{name: "frequency", args: []string{strconv.Itoa(windowMinutes), strconv.Itoa(threshold)}}
+	switch {
+	case windowMinutes <= 0:
+		f.err = fmt.Errorf("frequency window size %d is not positive", windowMinutes)
+	case threshold <= 0:
+		f.err = fmt.Errorf("frequency threshold %d is not positive", threshold)
+	}
+	return f
+}
+
+// SAX matches series whose symbolic aggregate approximation matches the pattern,
+// a regular expression over the letters of the alphabet. The series is split into
+// paaSize segments and the values are mapped to an alphabet of alphabetSize
+// (1 to 20) letters; threshold is the normalization threshold.
+func SAX(pattern string, paaSize, alphabetSize int, threshold float64) Function {
+	f := Function{name: "sax", args: []string{
+		pattern,
+		strconv.Itoa(paaSize),
+		strconv.Itoa(alphabetSize),
+		strconv.FormatFloat(threshold, 'g', -1, 64),
+	}}
+	switch {
+	case pattern == "":
+		f.err = fmt.Errorf("empty SAX pattern")
+	case strings.ContainsAny(pattern, ",;{}"):
+		f.err = fmt.Errorf("SAX pattern '%s' contains one of ',;{}'", pattern)
+	case paaSize <= 0:
+		f.err = fmt.Errorf("SAX PAA size %d is not positive", paaSize)
+	case alphabetSize < 1 || alphabetSize > 20:
+		f.err = fmt.Errorf("SAX alphabet size %d is not in [1, 20]", alphabetSize)
+	case threshold < 0:
+		f.err = fmt.Errorf("SAX threshold %v is negative", threshold)
+	}
+	return f
+}
+
+// Name returns the name of the function as used in the query and the results.
+func (f Function) Name() string {
+	return f.name
+}
+
+// String returns the function in the syntax of the cf query parameter, e.g. "p:0.5".
+func (f Function) String() string {
+	if len(f.args) == 0 {
+		return f.name
+	}
+	return f.name + ":" + strings.Join(f.args, ",")
+}
+
+// validate returns the error found when the function was created.
+func (f Function) validate() error {
+	if f.name == "" {
+		return fmt.Errorf("function without name, use the constructors to create functions")
+	}
+	if f.err != nil {
+		return fmt.Errorf("invalid function '%s': %v", f.name, f.err)
+	}
+	return nil
+}
+
+// A FunctionResult is the result of a Chronix function for one series.
+type FunctionResult struct {
+	// Name is the name of the function, e.g. "max" or "p".
+	Name string
+	// Arguments are the arguments of the function as returned by the server.
+	Arguments []string
+	// Value is the value computed by aggregations such as Max or Percentile.
+	Value float64
+	// Matched reports whether the series matched an analysis such as Trend.
+	Matched bool
+}
+
+// functionFieldRegexp matches the result fields of functions. The server
+// returns the value of the n-th function as "<n>_function_<name>" and its
+// arguments as "<n>_function_arguments_<name>".
+var functionFieldRegexp = regexp.MustCompile(`^(\d+)_function_(arguments_)?(.+)$`)
+
+// isFunctionField reports whether a document field holds a function result.
+func isFunctionField(field string) bool {
+	return functionFieldRegexp.MatchString(field)
+}
+
+// parseFunctionResults collects the function results of a document ordered by
+// the position of the functions in the query.
+func parseFunctionResults(doc map[string]interface{}) ([]FunctionResult, error) {
+	byIndex := map[int]*FunctionResult{}
+	for k, v := range doc {
+		m := functionFieldRegexp.FindStringSubmatch(k)
+		if m == nil {
+			continue
+		}
+		i, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid function index in field '%s': %v", k, err)
+		}
+		r, ok := byIndex[i]
+		if !ok {
+			r = &FunctionResult{Name: m[3]}
+			byIndex[i] = r
+		}
+
+		if m[2] != "" {
+			values, ok := v.([]interface{})
+			if !ok {
+				values = []interface{}{v}
+			}
+			for _, a := range values {
+				r.Arguments = append(r.Arguments, fmt.Sprint(a))
+			}
+			continue
+		}
+
+		switch value := v.(type) {
+		case json.Number:
+			if r.Value, err = value.Float64(); err != nil {
+				return nil, fmt.Errorf("invalid value of field '%s': %v", k, err)
+			}
+		case float64:
+			r.Value = value
+		case bool:
+			r.Matched = value
+		case string:
+			// Non-finite values such as NaN are returned as strings.
+			if r.Value, err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("invalid value of field '%s': %v", k, err)
+			}
+		case nil:
+		default:
+			return nil, fmt.Errorf("unexpected type %T of field '%s'", v, k)
+		}
+	}
+
+	if len(byIndex) == 0 {
+		return nil, nil
+	}
+	indices := make([]int, 0, len(byIndex))
+	for i := range byIndex {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	results := make([]FunctionResult, 0, len(indices))
+	for _, i := range indices {
+		results = append(results, *byIndex[i])
+	}
+	return results, nil
+}
@@ -0,0 +1,127 @@
+package chronix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestFunctionSyntax(t *testing.T) {
+	params, err := NewQueryBuilder().
+		Name("testmetric").
+		Functions(
+			Min(), Max(), Avg(), Percentile(0.99), Derivative(),
+			Trend(), Outlier(), Frequency(10, 6), SAX("*af*", 10, 6, 0.01),
+		).
+		Build(true)
+	if err != nil {
+		t.Fatal("Error building query:", err)
+	}
+	want := "metric{min;max;avg;p:0.99;derivative;trend;outlier;frequency:10,6;sax:*af*,10,6,0.01}"
+	if params.CF != want {
+		t.Fatalf("Unexpected cf parameter. Want:\n\n%s\n\nGot:\n\n%s", want, params.CF)
+	}
+}
+
+func TestFunctionValidation(t *testing.T) {
+	invalid := []Function{
+		{},
+		Percentile(0),
+		Percentile(1.5),
+		Frequency(0, 6),
+		Frequency(10, -1),
+		SAX("", 10, 6, 0.01),
+		SAX("a,b", 10, 6, 0.01),
+		SAX("*af*", 0, 6, 0.01),
+		SAX("*af*", 10, 21, 0.01),
+		SAX("*af*", 10, 6, -1),
+	}
+	for i, f := range invalid {
+		if _, err := NewQueryBuilder().Functions(f).Build(true); err == nil {
+			t.Errorf("%d. Expected an error for function %s", i, f)
+		}
+	}
+}
+
+func TestQuerySeriesWithFunctions(t *testing.T) {
+	params, err := NewQueryBuilder().
+		Name("testmetric").
+		Join("host").
+		Functions(Max(), Percentile(0.5), Trend()).
+		Build(true)
+	if err != nil {
+		t.Fatal("Error building query:", err)
+	}
+
+	resultJSON, err := json.Marshal(map[string]interface{}{
+		"response": map[string]interface{}{
+			"numFound": 1,
+			"docs": []map[string]interface{}{
+				{
+					"name":                       "testmetric",
+					"type":                       "metric",
+					"start":                      15,
+					"end":                        114,
+					"host_s":                     "testhost",
+					"0_function_max":             9900,
+					"1_function_p":               4950.5,
+					"1_function_arguments_p":     []string{"0.5"},
+					"2_function_trend":           true,
+					"2_function_arguments_trend": []string{},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal("Error marshalling result:", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+		if qs.Get("q") != params.Q || qs.Get("cj") != "host_s" || qs.Get("cf") != "metric{max;p:0.5;trend}" {
+			t.Fatalf("Unexpected query params: %v", qs)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resultJSON)
+	}))
+	defer server.Close()
+
+	c, err := createSolrClient(server, t, false)
+	series, err := c.QuerySeriesWithParams(context.Background(), params)
+	if err != nil {
+		t.Fatal("Error querying:", err)
+	}
+
+	want := []*TimeSeries{
+		{
+			Name:       "testmetric",
+			Type:       "metric",
+			Attributes: map[string]string{"host": "testhost"},
+			Functions: []FunctionResult{
+				{Name: "max", Value: 9900},
+				{Name: "p", Arguments: []string{"0.5"}, Value: 4950.5},
+				{Name: "trend", Matched: true},
+			},
+		},
+	}
+	if !reflect.DeepEqual(series, want) {
+		t.Fatalf("Unexpected series. Want:\n\n%+v\n\nGot:\n\n%+v", want[0], series[0])
+	}
+}
+
+func TestElasticRejectsFunctions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	storage, err := NewElasticTestStorage(&server.URL)
+	if err != nil {
+		t.Fatal("Error creating Elastic storage:", err)
+	}
+	_, err = storage.QueryWithParams(context.Background(), QueryParams{Q: "*:*", CF: "metric{max}"})
+	if err != ErrFunctionsNotSupported {
+		t.Fatalf("Expected ErrFunctionsNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package chronix
+
+import (
+	"context"
+	"errors"
+)
+
+// DefaultPageSize is the number of documents requested per page if no page size is given.
+const DefaultPageSize = 1000
+
+// ErrPagedJoinOrFunction is returned by a SeriesIterator for queries with joins or
+// functions. They would be evaluated per page, so their results would be wrong.
+var ErrPagedJoinOrFunction = errors.New("joins and functions cannot be evaluated page by page")
+
+// A SeriesIterator iterates over the time series chunks matching a query. The
+// chunks are requested from the storage page by page and decoded one at a time,
+// so that only one page of documents is held in memory.
+//
+//	it := c.IterateSeries(ctx, params, 0)
+//	defer it.Close()
+//	for it.Next() {
+//		ts := it.Series()
+//		// ...
+//	}
+//	if err := it.Err(); err != nil {
+//		// Handle error.
+//	}
+type SeriesIterator struct {
+	ctx      context.Context
+	storage  StorageClient
+	params   QueryParams
+	pageSize int
+
+	docs    []map[string]interface{}
+	cursor  string
+	done    bool
+	current *TimeSeries
+	err     error
+}
+
+func newSeriesIterator(ctx context.Context, storage StorageClient, params QueryParams, pageSize int) *SeriesIterator {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if params.FL == "" {
+		params.FL = "*"
+	}
+	it := &SeriesIterator{
+		ctx:      ctx,
+		storage:  storage,
+		params:   params,
+		pageSize: pageSize,
+	}
+	if params.CJ != "" || params.CF != "" {
+		it.err = ErrPagedJoinOrFunction
+	}
+	return it
+}
+
+// Next advances the iterator to the next chunk. It returns false if there are
+// no more chunks or an error occurred.
+func (it *SeriesIterator) Next() bool {
+	it.current = nil
+	if it.err != nil {
+		return false
+	}
+	for len(it.docs) == 0 {
+		if it.done {
+			return false
+		}
+		if err := it.fetch(); err != nil {
+			it.err = err
+			return false
+		}
+	}
+
+	doc := it.docs[0]
+	it.docs[0] = nil
+	it.docs = it.docs[1:]
+
+	ts, err := documentToTimeSeries(doc, it.storage.NeedPostfixOnDynamicField())
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.current = ts
+	return true
+}
+
+// fetch requests the next page of documents.
+func (it *SeriesIterator) fetch() error {
+	page, err := it.storage.QueryPage(it.ctx, it.params, it.cursor, it.pageSize)
+	if err != nil {
+		return err
+	}
+	it.docs = page.Docs
+	it.cursor = page.Cursor
+	it.done = page.Cursor == ""
+	return nil
+}
+
+// Series returns the current chunk. It is only valid after Next returned true.
+func (it *SeriesIterator) Series() *TimeSeries {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *SeriesIterator) Err() error {
+	return it.err
+}
+
+// Close releases the cursor held by the storage if the iteration stopped before
+// the last page. It is safe to call Close more than once.
+func (it *SeriesIterator) Close() error {
+	open := !it.done && it.cursor != ""
+	it.done = true
+	it.docs = nil
+	if !open {
+		return nil
+	}
+	// The context of the iterator may already be canceled.
+	return it.storage.CloseCursor(context.Background(), it.cursor)
+}
@@ -0,0 +1,228 @@
+package chronix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func iteratorTestDocs(n int) []map[string]interface{} {
+	docs := make([]map[string]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		docs = append(docs, map[string]interface{}{
+			"id":    fmt.Sprintf("%d", i),
+			"name":  "testmetric",
+			"type":  "metric",
+			"start": 15,
+			"end":   114,
+			"host":  fmt.Sprintf("testhost_%d", i),
+		})
+	}
+	return docs
+}
+
+func collectSeries(t *testing.T, it *SeriesIterator) []string {
+	var hosts []string
+	for it.Next() {
+		hosts = append(hosts, it.Series().Attributes["host"])
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal("Error iterating:", err)
+	}
+	return hosts
+}
+
+func TestSolrSeriesIterator(t *testing.T) {
+	docs := iteratorTestDocs(5)
+	for _, doc := range docs {
+		doc["host_s"] = doc["host"]
+		delete(doc, "host")
+	}
+
+	var cursors []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+		if qs.Get("q") != "name:testmetric" || qs.Get("rows") != "2" || qs.Get("sort") != "id asc" {
+			t.Fatalf("Unexpected query params: %v", qs)
+		}
+		cursor := qs.Get("cursorMark")
+		cursors = append(cursors, cursor)
+
+		// The cursor is the offset of the next document.
+		offset := 0
+		if cursor != "*" {
+			fmt.Sscanf(cursor, "%d", &offset)
+		}
+		end := offset + 2
+		if end > len(docs) {
+			end = len(docs)
+		}
+		next := fmt.Sprintf("%d", end)
+		if offset == end {
+			next = cursor
+		}
+		resp, err := json.Marshal(map[string]interface{}{
+			"response":       map[string]interface{}{"docs": docs[offset:end]},
+			"nextCursorMark": next,
+		})
+		if err != nil {
+			t.Fatal("Error marshalling response:", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	}))
+	defer server.Close()
+
+	c, err := createSolrClient(server, t, false)
+	if err != nil {
+		t.Fatal("Error creating client:", err)
+	}
+
+	hosts := collectSeries(t, c.IterateSeries(context.Background(), QueryParams{Q: "name:testmetric"}, 2))
+	want := []string{"testhost_0", "testhost_1", "testhost_2", "testhost_3", "testhost_4"}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Fatalf("Unexpected series. Want %v, got %v", want, hosts)
+	}
+	if wantCursors := []string{"*", "2", "4", "5"}; !reflect.DeepEqual(cursors, wantCursors) {
+		t.Fatalf("Unexpected cursors. Want %v, got %v", wantCursors, cursors)
+	}
+}
+
+func TestSolrSeriesIteratorError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c, err := createSolrClient(server, t, false)
+	if err != nil {
+		t.Fatal("Error creating client:", err)
+	}
+
+	it := c.IterateSeries(context.Background(), QueryParams{Q: "*:*"}, 0)
+	if it.Next() {
+		t.Fatal("Expected no series")
+	}
+	if it.Err() == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestElasticSeriesIterator(t *testing.T) {
+	docs := iteratorTestDocs(3)
+	pages := [][]map[string]interface{}{docs[:2], docs[2:], nil}
+
+	var paths []string
+	cleared := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/_search/scroll") {
+			cleared = true
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"succeeded":true,"num_freed":1}`))
+			return
+		}
+		paths = append(paths, r.URL.Path)
+		if len(paths) > len(pages) {
+			t.Fatal("Unexpected request:", r.Method, r.URL)
+		}
+
+		hits := []map[string]interface{}{}
+		for i, doc := range pages[len(paths)-1] {
+			hits = append(hits, map[string]interface{}{"_index": "chronix", "_type": "doc", "_id": fmt.Sprint(i), "_source": doc})
+		}
+		resp, err := json.Marshal(map[string]interface{}{
+			"_scroll_id": "scroll",
+			"hits":       map[string]interface{}{"total": len(docs), "hits": hits},
+		})
+		if err != nil {
+			t.Fatal("Error marshalling response:", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	}))
+	defer server.Close()
+
+	c := createElasticClient(server, false, t)
+	hosts := collectSeries(t, c.IterateSeries(context.Background(), QueryParams{Q: "name:testmetric"}, 2))
+	want := []string{"testhost_0", "testhost_1", "testhost_2"}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Fatalf("Unexpected series. Want %v, got %v", want, hosts)
+	}
+	wantPaths := []string{"/chronix/_search", "/_search/scroll", "/_search/scroll"}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Fatalf("Unexpected requests. Want %v, got %v", wantPaths, paths)
+	}
+	if !cleared {
+		t.Error("Expected the scroll to be cleared")
+	}
+}
+
+func TestElasticSeriesIteratorClose(t *testing.T) {
+	docs := iteratorTestDocs(2)
+	var cleared []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/_search/scroll") {
+			body, _ := ioutil.ReadAll(r.Body)
+			cleared = append(cleared, string(body))
+			w.Write([]byte(`{"succeeded":true,"num_freed":1}`))
+			return
+		}
+		if r.URL.Path != "/chronix/_search" {
+			t.Fatal("Unexpected request:", r.Method, r.URL)
+		}
+		hits := []map[string]interface{}{}
+		for i, doc := range docs {
+			hits = append(hits, map[string]interface{}{"_index": "chronix", "_type": "doc", "_id": fmt.Sprint(i), "_source": doc})
+		}
+		resp, err := json.Marshal(map[string]interface{}{
+			"_scroll_id": "scroll",
+			"hits":       map[string]interface{}{"total": 10, "hits": hits},
+		})
+		if err != nil {
+			t.Fatal("Error marshalling response:", err)
+		}
+		w.Write(resp)
+	}))
+	defer server.Close()
+
+	c := createElasticClient(server, false, t)
+	it := c.IterateSeries(context.Background(), QueryParams{Q: "name:testmetric"}, 2)
+	if !it.Next() {
+		t.Fatal("Expected a series, got error:", it.Err())
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal("Error closing iterator:", err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal("Error closing iterator twice:", err)
+	}
+	if len(cleared) != 1 || !strings.Contains(cleared[0], "scroll") {
+		t.Fatalf("Expected the scroll to be cleared once, got %v", cleared)
+	}
+	if it.Next() {
+		t.Fatal("Expected no series after closing the iterator")
+	}
+}
+
+func TestSeriesIteratorRejectsJoinsAndFunctions(t *testing.T) {
+	c := New(&recordingStorage{})
+	for _, params := range []QueryParams{
+		{Q: "name:testmetric", CJ: "host"},
+		{Q: "name:testmetric", CF: "metric{max}"},
+	} {
+		it := c.IterateSeries(context.Background(), params, 0)
+		if it.Next() {
+			t.Errorf("Expected no series for %+v", params)
+		}
+		if it.Err() != ErrPagedJoinOrFunction {
+			t.Errorf("Expected ErrPagedJoinOrFunction for %+v, got %v", params, it.Err())
+		}
+	}
+}
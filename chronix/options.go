@@ -0,0 +1,61 @@
+package chronix
+
+// A ClientOption configures a client created by NewClient.
+type ClientOption func(*Options)
+
+// NewClient creates a new Chronix client with the given options. Without options,
+// the client is like the one created by New.
+func NewClient(s StorageClient, opts ...ClientOption) Client {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return NewWithOptions(s, o)
+}
+
+// WithStatistics enables the creation of statistics for the individual data chunks,
+// including the given optional statistics.
+func WithStatistics(extra ...Statistic) ClientOption {
+	return func(o *Options) {
+		o.CreateStatistics = true
+		for _, s := range extra {
+			o.Statistics |= s
+		}
+	}
+}
+
+// WithSpecialValues defines how NaN and infinite values are treated by the statistics.
+func WithSpecialValues(policy SpecialValuePolicy) ClientOption {
+	return func(o *Options) {
+		o.SpecialValues = policy
+	}
+}
+
+// WithStatsCalculators adds calculators of further statistics for the individual data chunks.
+func WithStatsCalculators(calculators ...StatsCalculator) ClientOption {
+	return func(o *Options) {
+		o.StatsCalculators = append(o.StatsCalculators, calculators...)
+	}
+}
+
+// WithDDCThreshold sets the date-delta-compaction threshold in milliseconds
+// (see Options.DDCThreshold).
+func WithDDCThreshold(threshold uint32) ClientOption {
+	return func(o *Options) {
+		o.DDCThreshold = threshold
+	}
+}
+
+// WithChunking configures how series are split into chunks when storing them.
+func WithChunking(policy ChunkingPolicy) ClientOption {
+	return func(o *Options) {
+		o.Chunking = policy
+	}
+}
+
+// WithDuplicates defines how points of a series with the same timestamp are handled.
+func WithDuplicates(policy DuplicatePolicy) ClientOption {
+	return func(o *Options) {
+		o.Duplicates = policy
+	}
+}
@@ -0,0 +1,118 @@
+package chronix
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewClient(t *testing.T) {
+	storage := &recordingStorage{}
+	chunking := ChunkingPolicy{MaxPoints: 100, Window: time.Hour}
+
+	tests := []struct {
+		opts []ClientOption
+		want Client
+	}{
+		{
+			want: New(storage),
+		},
+		{
+			opts: []ClientOption{WithStatistics()},
+			want: NewWithStatistics(storage),
+		},
+		{
+			opts: []ClientOption{
+				WithStatistics(StatSum, StatP50|StatP99),
+				WithSpecialValues(IncludeInfinity),
+				WithDDCThreshold(10),
+				WithChunking(chunking),
+				WithDuplicates(DuplicatesError),
+			},
+			want: NewWithOptions(storage, Options{
+				CreateStatistics: true,
+				Statistics:       StatSum | StatP50 | StatP99,
+				SpecialValues:    IncludeInfinity,
+				DDCThreshold:     10,
+				Chunking:         chunking,
+				Duplicates:       DuplicatesError,
+			}),
+		},
+	}
+
+	for i, test := range tests {
+		if got := NewClient(storage, test.opts...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d. Unexpected client. Want %+v, got %+v", i, test.want, got)
+		}
+	}
+}
+
+func TestNewClientWithStatsCalculators(t *testing.T) {
+	storage := &recordingStorage{}
+	c := NewClient(storage, WithStatsCalculators(counterStats), WithStatistics())
+
+	series := []*TimeSeries{{Name: "Test", Type: "metric", Points: pointsFrom(1, 3)}}
+	if err := c.Store(series, false, 0); err != nil {
+		t.Fatal("Failed to store series", err)
+	}
+	doc := storage.documents()[0]
+	if _, ok := doc["stats_rate_f"]; !ok {
+		t.Error("Expected the statistics of the calculator, got ", doc)
+	}
+	if _, ok := doc["stats_count_f"]; !ok {
+		t.Error("Expected the built-in statistics, got ", doc)
+	}
+}
+
+func TestNewSolr(t *testing.T) {
+	u, err := url.Parse("http://localhost:8983/solr/chronix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &http.Transport{}
+
+	tests := []struct {
+		opts []SolrOption
+		want StorageClient
+	}{
+		{
+			want: NewSolrStorage(u, nil),
+		},
+		{
+			opts: []SolrOption{WithSolrTransport(transport), WithSolrRetry(DefaultRetryPolicy)},
+			want: NewSolrStorageWithRetry(u, transport, DefaultRetryPolicy),
+		},
+	}
+
+	for i, test := range tests {
+		if got := NewSolr(u, test.opts...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d. Unexpected storage. Want %+v, got %+v", i, test.want, got)
+		}
+	}
+}
+
+func TestElasticOptions(t *testing.T) {
+	index := ElasticIndex{Name: "chronix-{2006.01.02}"}
+	var opts ElasticOptions
+	for _, opt := range []ElasticOption{
+		WithElasticIndex(index),
+		WithElasticIndexSetup(true),
+		WithElasticSniff(false),
+		WithElasticRetry(DefaultRetryPolicy),
+	} {
+		opt(&opts)
+	}
+
+	want := ElasticOptions{
+		WithIndex:      true,
+		DeleteIfExists: true,
+		DisableSniff:   true,
+		Index:          index,
+		Retry:          DefaultRetryPolicy,
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("Unexpected options. Want %+v, got %+v", want, opts)
+	}
+}
@@ -0,0 +1,234 @@
+package chronix
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QueryParams are the rendered parameters of a Chronix query.
+type QueryParams struct {
+	// Q is the query selecting the chunks.
+	Q string
+	// CJ is the comma-separated list of fields the chunks are joined on.
+	CJ string
+	// FL is the comma-separated list of fields to return.
+	FL string
+	// CF are the Chronix functions to evaluate on the server.
+	CF string
+}
+
+type matchKind int
+
+const (
+	matchEquals matchKind = iota
+	matchRegex
+	matchPrefix
+)
+
+type attributeMatcher struct {
+	attribute string
+	kind      matchKind
+	value     string
+	negate    bool
+}
+
+// A QueryBuilder builds Chronix queries without hand-writing query strings.
+// Values are escaped and attribute names get the postfix of dynamic fields
+// if the storage needs one.
+type QueryBuilder struct {
+	name       string
+	typ        string
+	matchers   []attributeMatcher
+	start, end time.Time
+	join       []string
+	functions  []Function
+	fields     []string
+}
+
+// NewQueryBuilder creates a new QueryBuilder.
+func NewQueryBuilder() *QueryBuilder {
+	return &QueryBuilder{}
+}
+
+// Name selects the chunks of the series with the given name.
+func (b *QueryBuilder) Name(name string) *QueryBuilder {
+	b.name = name
+	return b
+}
+
+// Type selects the chunks of the series with the given type.
+func (b *QueryBuilder) Type(typ string) *QueryBuilder {
+	b.typ = typ
+	return b
+}
+
+// AttributeEquals selects the chunks whose attribute has the given value.
+func (b *QueryBuilder) AttributeEquals(attribute, value string) *QueryBuilder {
+	b.matchers = append(b.matchers, attributeMatcher{attribute, matchEquals, value, false})
+	return b
+}
+
+// AttributeRegex selects the chunks whose attribute matches the regular expression.
+// The expression uses the Lucene regular expression syntax and has to match the whole value.
+func (b *QueryBuilder) AttributeRegex(attribute, regex string) *QueryBuilder {
+	b.matchers = append(b.matchers, attributeMatcher{attribute, matchRegex, regex, false})
+	return b
+}
+
+// AttributeNotEquals selects the chunks whose attribute does not have the given value,
+// including the chunks without the attribute.
+func (b *QueryBuilder) AttributeNotEquals(attribute, value string) *QueryBuilder {
+	b.matchers = append(b.matchers, attributeMatcher{attribute, matchEquals, value, true})
+	return b
+}
+
+// AttributeNotRegex selects the chunks whose attribute does not match the regular
+// expression, including the chunks without the attribute.
+func (b *QueryBuilder) AttributeNotRegex(attribute, regex string) *QueryBuilder {
+	b.matchers = append(b.matchers, attributeMatcher{attribute, matchRegex, regex, true})
+	return b
+}
+
+// AttributePrefix selects the chunks whose attribute starts with the prefix.
+func (b *QueryBuilder) AttributePrefix(attribute, prefix string) *QueryBuilder {
+	b.matchers = append(b.matchers, attributeMatcher{attribute, matchPrefix, prefix, false})
+	return b
+}
+
+// Range selects the chunks overlapping the time range. A zero start or end leaves
+// the range open on that side.
+func (b *QueryBuilder) Range(start, end time.Time) *QueryBuilder {
+	b.start, b.end = start, end
+	return b
+}
+
+// Join joins the chunks on the given attributes. "name" and "type" refer to the
+// name and type of the series.
+func (b *QueryBuilder) Join(attributes ...string) *QueryBuilder {
+	b.join = append(b.join, attributes...)
+	return b
+}
+
+// Function adds a Chronix function to evaluate on the server, e.g. "max" or "p:0.5".
+// The function is passed to the server as is, use Functions to add validated functions.
+func (b *QueryBuilder) Function(function string) *QueryBuilder {
+	b.functions = append(b.functions, Function{name: function})
+	return b
+}
+
+// Functions adds Chronix functions to evaluate on the server, e.g. Max() or
+// Percentile(0.5). Their results are returned in TimeSeries.Functions.
+func (b *QueryBuilder) Functions(functions ...Function) *QueryBuilder {
+	b.functions = append(b.functions, functions...)
+	return b
+}
+
+// Fields sets the fields to return, e.g. "dataAsJson".
+func (b *QueryBuilder) Fields(fields ...string) *QueryBuilder {
+	b.fields = append(b.fields, fields...)
+	return b
+}
+
+// Build renders the query parameters for a storage. The postfix of dynamic fields
+// is appended to attribute names if postfixOnDynamicField is set, which is the
+// case for Solr (see StorageClient.NeedPostfixOnDynamicField).
+func (b *QueryBuilder) Build(postfixOnDynamicField bool) (QueryParams, error) {
+	var params QueryParams
+
+	attributeField := func(attribute string) string {
+		if postfixOnDynamicField && attribute != "name" && attribute != "type" {
+			return attribute + "_s"
+		}
+		return attribute
+	}
+
+	var clauses []string
+	if b.name != "" {
+		clauses = append(clauses, "name:"+quoteQueryValue(b.name))
+	}
+	if b.typ != "" {
+		clauses = append(clauses, "type:"+quoteQueryValue(b.typ))
+	}
+	for _, m := range b.matchers {
+		if m.attribute == "" {
+			return params, errors.New("attribute matcher without attribute name")
+		}
+		field := attributeField(m.attribute)
+		if m.negate {
+			field = "-" + field
+		}
+		switch m.kind {
+		case matchEquals:
+			clauses = append(clauses, field+":"+quoteQueryValue(m.value))
+		case matchRegex:
+			clauses = append(clauses, field+":/"+strings.Replace(m.value, "/", `\/`, -1)+"/")
+		case matchPrefix:
+			clauses = append(clauses, field+":"+escapeQueryValue(m.value)+"*")
+		}
+	}
+	if !b.start.IsZero() && !b.end.IsZero() && b.start.After(b.end) {
+		return params, fmt.Errorf("start %v is after end %v", b.start, b.end)
+	}
+	if !b.start.IsZero() {
+		clauses = append(clauses, fmt.Sprintf("start:%d", toMillis(b.start)))
+	}
+	if !b.end.IsZero() {
+		clauses = append(clauses, fmt.Sprintf("end:%d", toMillis(b.end)))
+	}
+
+	if len(clauses) == 0 {
+		params.Q = "*:*"
+	} else {
+		params.Q = strings.Join(clauses, " AND ")
+	}
+
+	join := make([]string, 0, len(b.join))
+	for _, j := range b.join {
+		join = append(join, attributeField(j))
+	}
+	params.CJ = strings.Join(join, ",")
+	params.FL = strings.Join(b.fields, ",")
+
+	if len(b.functions) > 0 {
+		functions := make([]string, 0, len(b.functions))
+		for _, f := range b.functions {
+			if err := f.validate(); err != nil {
+				return params, err
+			}
+			functions = append(functions, f.String())
+		}
+		typ := b.typ
+		if typ == "" {
+			typ = "metric"
+		}
+		params.CF = typ + "{" + strings.Join(functions, ";") + "}"
+	}
+	return params, nil
+}
+
+// toMillis converts a time to milliseconds since the epoch.
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// quoteQueryValue quotes a value as a phrase.
+func quoteQueryValue(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	return `"` + v + `"`
+}
+
+// escapeQueryValue escapes the special characters of the Lucene query syntax.
+func escapeQueryValue(v string) string {
+	var buf bytes.Buffer
+	for _, c := range v {
+		if strings.ContainsRune(`\+-!():^[]"{}~*?|&/ `, c) {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
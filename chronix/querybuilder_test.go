@@ -0,0 +1,118 @@
+package chronix
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryBuilder(t *testing.T) {
+	start := time.Unix(1471517965, 0)
+	end := time.Unix(1471518965, 0)
+
+	tests := []struct {
+		builder *QueryBuilder
+		postfix bool
+		want    QueryParams
+	}{
+		{
+			builder: NewQueryBuilder(),
+			want:    QueryParams{Q: "*:*"},
+		},
+		{
+			builder: NewQueryBuilder().
+				Name("testmetric").
+				Type("metric").
+				AttributeEquals("host", `web "01"`).
+				AttributeRegex("dc", "eu/[a-z]+").
+				AttributePrefix("rack", "r-1").
+				AttributeNotEquals("env", "dev").
+				AttributeNotRegex("name", "test_.*").
+				Range(start, end).
+				Join("name", "host").
+				Function("max").
+				Function("p:0.5").
+				Fields("dataAsJson"),
+			postfix: true,
+			want: QueryParams{
+				Q:  `name:"testmetric" AND type:"metric" AND host_s:"web \"01\"" AND dc_s:/eu\/[a-z]+/ AND rack_s:r\-1* AND -env_s:"dev" AND -name:/test_.*/ AND start:1471517965000 AND end:1471518965000`,
+				CJ: "name,host_s",
+				FL: "dataAsJson",
+				CF: "metric{max;p:0.5}",
+			},
+		},
+		{
+			builder: NewQueryBuilder().
+				Name("testmetric").
+				AttributeEquals("host", "testhost").
+				Range(start, time.Time{}).
+				Join("host"),
+			want: QueryParams{
+				Q:  `name:"testmetric" AND host:"testhost" AND start:1471517965000`,
+				CJ: "host",
+			},
+		},
+	}
+
+	for i, test := range tests {
+		got, err := test.builder.Build(test.postfix)
+		if err != nil {
+			t.Fatalf("%d. Error building query: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d. Unexpected query. Want:\n\n%+v\n\nGot:\n\n%+v", i, test.want, got)
+		}
+	}
+}
+
+func TestQueryBuilderErrors(t *testing.T) {
+	builders := []*QueryBuilder{
+		NewQueryBuilder().AttributeEquals("", "value"),
+		NewQueryBuilder().Range(time.Unix(2, 0), time.Unix(1, 0)),
+	}
+	for i, b := range builders {
+		if _, err := b.Build(false); err == nil {
+			t.Errorf("%d. Expected an error", i)
+		}
+	}
+}
+
+func TestQueryBuilderElasticTranslation(t *testing.T) {
+	params, err := NewQueryBuilder().
+		Name("testmetric").
+		AttributeEquals("host", `web "01"`).
+		AttributeRegex("dc", "eu/[a-z]+").
+		AttributePrefix("rack", "r-1").
+		AttributeNotEquals("env", "dev").
+		Range(time.Unix(15, 0), time.Unix(114, 0)).
+		Build(false)
+	if err != nil {
+		t.Fatal("Error building query:", err)
+	}
+
+	query, err := translateQuery(params.Q, time.Now())
+	if err != nil {
+		t.Fatal("Error translating query:", err)
+	}
+	src, err := query.Source()
+	if err != nil {
+		t.Fatal("Error getting query source:", err)
+	}
+	got, err := json.Marshal(src)
+	if err != nil {
+		t.Fatal("Error marshalling query:", err)
+	}
+
+	want := `{"bool":{"filter":[` +
+		`{"match_phrase":{"name":{"query":"testmetric"}}},` +
+		`{"match_phrase":{"host":{"query":"web \"01\""}}},` +
+		`{"regexp":{"dc":{"value":"eu/[a-z]+"}}},` +
+		`{"prefix":{"rack":"r-1"}},` +
+		`{"range":{"end":{"from":15000,"include_lower":true,"include_upper":true,"to":null}}},` +
+		`{"range":{"start":{"from":null,"include_lower":true,"include_upper":true,"to":114000}}}],` +
+		`"must_not":{"match_phrase":{"env":{"query":"dev"}}}}}`
+	if string(got) != want {
+		t.Fatalf("Unexpected Elasticsearch query. Want:\n\n%s\n\nGot:\n\n%s", want, got)
+	}
+}
@@ -0,0 +1,133 @@
+package chronix
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// reservedFields are the document fields that are not time series attributes.
+var reservedFields = map[string]bool{
+	"id":        true,
+	"_version_": true,
+	"data":      true,
+	"start":     true,
+	"end":       true,
+	"name":      true,
+	"type":      true,
+}
+
+// queryResponse models the parts of a Solr select response we are interested in.
+type queryResponse struct {
+	Response struct {
+		NumFound int64                    `json:"numFound"`
+		Docs     []map[string]interface{} `json:"docs"`
+	} `json:"response"`
+}
+
+// DecodeQueryResponse decodes the raw response of a storage query into time series
+// chunks. The postfix of dynamic fields is removed from the attribute names if
+// postfixOnDynamicField is set (see StorageClient.NeedPostfixOnDynamicField).
+func DecodeQueryResponse(body []byte, postfixOnDynamicField bool) ([]*TimeSeries, error) {
+	return parseQueryResponse(body, postfixOnDynamicField)
+}
+
+// parseQueryResponse decodes a raw storage response into time series.
+func parseQueryResponse(body []byte, postfixOnDynamicField bool) ([]*TimeSeries, error) {
+	var resp queryResponse
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&resp); err != nil {
+		return nil, fmt.Errorf("error unmarshalling query response: %v", err)
+	}
+
+	series := make([]*TimeSeries, 0, len(resp.Response.Docs))
+	for _, doc := range resp.Response.Docs {
+		ts, err := documentToTimeSeries(doc, postfixOnDynamicField)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, ts)
+	}
+	return series, nil
+}
+
+// documentToTimeSeries converts one stored chunk document into a time series.
+func documentToTimeSeries(doc map[string]interface{}, postfixOnDynamicField bool) (*TimeSeries, error) {
+	start, err := int64Field(doc, "start")
+	if err != nil {
+		return nil, err
+	}
+	end, err := int64Field(doc, "end")
+	if err != nil {
+		return nil, err
+	}
+
+	ts := &TimeSeries{
+		Name:       stringField(doc["name"]),
+		Type:       stringField(doc["type"]),
+		Attributes: map[string]string{},
+	}
+
+	for k, v := range doc {
+		if reservedFields[k] || isFunctionField(k) {
+			continue
+		}
+		if postfixOnDynamicField {
+			if !strings.HasSuffix(k, "_s") {
+				continue
+			}
+			k = strings.TrimSuffix(k, "_s")
+		}
+		if s, ok := v.(string); ok {
+			ts.Attributes[k] = s
+		}
+	}
+
+	if ts.Functions, err = parseFunctionResults(doc); err != nil {
+		return nil, err
+	}
+
+	encData := stringField(doc["data"])
+	if encData == "" {
+		return ts, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(encData)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding base64 data: %v", err)
+	}
+	ts.Points, err = decode(data, start, end, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding points: %v", err)
+	}
+	return ts, nil
+}
+
+// stringField returns the value of a (possibly multi-valued) string field.
+func stringField(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []interface{}:
+		if len(s) > 0 {
+			return stringField(s[0])
+		}
+	}
+	return ""
+}
+
+func int64Field(doc map[string]interface{}, field string) (int64, error) {
+	switch v := doc[field].(type) {
+	case json.Number:
+		return v.Int64()
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case nil:
+		return 0, fmt.Errorf("document has no '%s' field", field)
+	default:
+		return 0, fmt.Errorf("unexpected type %T of field '%s'", v, field)
+	}
+}
@@ -0,0 +1,144 @@
+package chronix
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A RetryPolicy configures how the storage clients retry failed updates.
+//
+// Updates are retried on transport errors and on the configured HTTP status codes.
+// If retries are enabled, every document without an "id" field gets a generated
+// one before the first attempt, so that a retried document overwrites an already
+// acknowledged copy instead of duplicating it.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one.
+	// Values below 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the time to wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the time to wait between two attempts.
+	MaxBackoff time.Duration
+	// Multiplier is the factor the backoff grows with after each attempt.
+	Multiplier float64
+	// Jitter is the fraction of the backoff that is randomized, e.g. 0.2 for +/-20%.
+	Jitter float64
+	// RetryableStatusCodes are the HTTP status codes of responses that are retried.
+	RetryableStatusCodes []int
+}
+
+// DefaultRetryPolicy is a reasonable retry policy for most setups.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:          5,
+	InitialBackoff:       100 * time.Millisecond,
+	MaxBackoff:           10 * time.Second,
+	Multiplier:           2,
+	Jitter:               0.2,
+	RetryableStatusCodes: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+}
+
+func (p RetryPolicy) enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p RetryPolicy) retryable(statusCode int) bool {
+	for _, c := range p.RetryableStatusCodes {
+		if c == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the time to wait after the given (1-based) attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*mathrand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// wait blocks for the given duration or until the context is done.
+func wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryAfter parses the Retry-After header of a response. It returns 0 if the
+// header is missing or invalid.
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(time.Now()); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// withDocumentIDs returns the documents with a generated "id" field for every
+// document that has none. The given documents are not modified.
+func withDocumentIDs(data []map[string]interface{}) ([]map[string]interface{}, error) {
+	result := make([]map[string]interface{}, 0, len(data))
+	for _, doc := range data {
+		if _, ok := doc["id"]; ok {
+			result = append(result, doc)
+			continue
+		}
+		id, err := newDocumentID()
+		if err != nil {
+			return nil, err
+		}
+		withID := make(map[string]interface{}, len(doc)+1)
+		for k, v := range doc {
+			withID[k] = v
+		}
+		withID["id"] = id
+		result = append(result, withID)
+	}
+	return result, nil
+}
+
+// newDocumentID generates a random (version 4) UUID.
+func newDocumentID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", fmt.Errorf("error generating document id: %v", err)
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
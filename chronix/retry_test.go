@@ -0,0 +1,154 @@
+package chronix
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts:          3,
+	InitialBackoff:       time.Millisecond,
+	MaxBackoff:           10 * time.Millisecond,
+	Multiplier:           2,
+	RetryableStatusCodes: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("Unexpected backoff for attempt %d; want %v, got %v", i+1, w, got)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(1); got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("Backoff %v is out of the jitter range", got)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	if d := retryAfter(resp); d != 0 {
+		t.Error("Expected no delay without header, got", d)
+	}
+	resp.Header.Set("Retry-After", "2")
+	if d := retryAfter(resp); d != 2*time.Second {
+		t.Error("Expected a delay of 2s, got", d)
+	}
+	resp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if d := retryAfter(resp); d < 59*time.Minute {
+		t.Error("Expected a delay of about an hour, got", d)
+	}
+}
+
+func createRetrySolrClient(t *testing.T, handler http.HandlerFunc) (StorageClient, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	u, err := url.Parse(server.URL + "/solr/chronix")
+	if err != nil {
+		t.Fatal("Error parsing Solr URL:", err)
+	}
+	return NewSolrStorageWithRetry(u, nil, testRetryPolicy), server
+}
+
+func TestSolrUpdateRetries(t *testing.T) {
+	var ids []interface{}
+	attempts := 0
+	storage, server := createRetrySolrClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal("Error reading request body:", err)
+		}
+		var docs []map[string]interface{}
+		if err := json.Unmarshal(body, &docs); err != nil {
+			t.Fatal("Error unmarshalling body:", err)
+		}
+		ids = append(ids, docs[0]["id"])
+
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if attempts == 2 {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+	defer server.Close()
+
+	doc := map[string]interface{}{"name": "testmetric"}
+	if err := storage.Update([]map[string]interface{}{doc}, false, 0); err != nil {
+		t.Fatal("Expected the update to succeed after retries, got", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", attempts)
+	}
+	if ids[0] == nil || ids[0] != ids[1] || ids[1] != ids[2] {
+		t.Errorf("Expected every attempt to send the same document id, got %v", ids)
+	}
+	if _, ok := doc["id"]; ok {
+		t.Error("Expected the passed document not to be modified")
+	}
+}
+
+func TestSolrUpdateDoesNotRetryOtherErrors(t *testing.T) {
+	attempts := 0
+	storage, server := createRetrySolrClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	defer server.Close()
+
+	if err := storage.Update([]map[string]interface{}{{"name": "testmetric"}}, false, 0); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if attempts != 1 {
+		t.Fatalf("Expected 1 attempt, got %d", attempts)
+	}
+}
+
+func TestSolrUpdateGivesUpAfterMaxAttempts(t *testing.T) {
+	attempts := 0
+	storage, server := createRetrySolrClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer server.Close()
+
+	if err := storage.Update([]map[string]interface{}{{"name": "testmetric"}}, false, 0); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if attempts != testRetryPolicy.MaxAttempts {
+		t.Fatalf("Expected %d attempts, got %d", testRetryPolicy.MaxAttempts, attempts)
+	}
+}
+
+func TestSolrUpdateStopsRetryingOnCancel(t *testing.T) {
+	storage, server := createRetrySolrClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := storage.UpdateContext(ctx, []map[string]interface{}{{"name": "testmetric"}}, false, 0); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("Expected the retry to stop when the context is done")
+	}
+}
@@ -2,6 +2,7 @@ package chronix
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,46 +10,88 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"time"
 )
 
 // CancelableTransport is like net.Transport but provides
 // per-request cancelation functionality.
+//
+// Deprecated: Requests are canceled through their context. Use the context-aware
+// methods of the StorageClient instead; NewSolrStorage accepts any http.RoundTripper.
 type CancelableTransport interface {
 	http.RoundTripper
 	CancelRequest(req *http.Request)
 }
 
 // DefaultTransport is used by the solrClient when no explicit transport is provided.
-var DefaultTransport CancelableTransport = &http.Transport{
+var DefaultTransport http.RoundTripper = &http.Transport{
 	Proxy: http.ProxyFromEnvironment,
-	Dial: (&net.Dialer{
+	DialContext: (&net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
-	}).Dial,
+	}).DialContext,
 	TLSHandshakeTimeout: 10 * time.Second,
 }
 
 type solrClient struct {
 	url        *url.URL
 	httpClient http.Client
+	retry      RetryPolicy
 }
 
 // NewSolrStorage creates a new Solr client.
-func NewSolrStorage(url *url.URL, transport CancelableTransport) StorageClient {
-	if transport == nil {
-		transport = DefaultTransport
-	}
-	return &solrClient{
+func NewSolrStorage(url *url.URL, transport http.RoundTripper) StorageClient {
+	return NewSolr(url, WithSolrTransport(transport))
+}
+
+// A SolrOption configures a Solr client created by NewSolr.
+type SolrOption func(*solrClient)
+
+// NewSolr creates a new Solr client with the given options. Without options, the
+// client uses the DefaultTransport and does not retry failed updates.
+func NewSolr(url *url.URL, opts ...SolrOption) StorageClient {
+	c := &solrClient{
 		url: url,
 		httpClient: http.Client{
-			Transport: transport,
+			Transport: DefaultTransport,
 		},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithSolrTransport sets the transport of the HTTP requests to Solr.
+func WithSolrTransport(transport http.RoundTripper) SolrOption {
+	return func(c *solrClient) {
+		if transport != nil {
+			c.httpClient.Transport = transport
+		}
+	}
 }
 
-// Update implements SolrClient.
+// WithSolrRetry retries failed updates according to the given policy.
+func WithSolrRetry(retry RetryPolicy) SolrOption {
+	return func(c *solrClient) {
+		c.retry = retry
+	}
+}
+
+// NewSolrStorageWithRetry creates a new Solr client that retries failed updates
+// according to the given policy.
+func NewSolrStorageWithRetry(url *url.URL, transport http.RoundTripper, retry RetryPolicy) StorageClient {
+	return NewSolr(url, WithSolrTransport(transport), WithSolrRetry(retry))
+}
+
+// Update implements StorageClient.
 func (c *solrClient) Update(data []map[string]interface{}, commit bool, commitWithin time.Duration) error {
+	return c.UpdateContext(context.Background(), data, commit, commitWithin)
+}
+
+// UpdateContext implements StorageClient.
+func (c *solrClient) UpdateContext(ctx context.Context, data []map[string]interface{}, commit bool, commitWithin time.Duration) error {
 	u := *c.url
 	u.Path = path.Join(c.url.Path, "/update")
 	qs := u.Query()
@@ -60,38 +103,82 @@ func (c *solrClient) Update(data []map[string]interface{}, commit bool, commitWi
 	}
 	u.RawQuery = qs.Encode()
 
+	if c.retry.enabled() {
+		var err error
+		if data, err = withDocumentIDs(data); err != nil {
+			return err
+		}
+	}
+
 	buf, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("error marshalling JSON: %v", err)
 	}
-	req, err := http.NewRequest("POST", u.String(), bytes.NewBuffer(buf))
+
+	for attempt := 1; ; attempt++ {
+		retryable, delay, err := c.update(ctx, u.String(), buf)
+		if err == nil || !retryable || attempt >= c.retry.attempts() {
+			return err
+		}
+		if delay == 0 {
+			delay = c.retry.backoff(attempt)
+		}
+		if werr := wait(ctx, delay); werr != nil {
+			return err
+		}
+	}
+}
+
+// update sends one update request. It returns whether a failed request can be
+// retried and the delay requested by the server.
+func (c *solrClient) update(ctx context.Context, u string, buf []byte) (bool, time.Duration, error) {
+	req, err := http.NewRequest("POST", u, bytes.NewBuffer(buf))
 	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
+		return false, 0, fmt.Errorf("error creating request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
 	if err != nil {
-		return fmt.Errorf("error sending request: %v", err)
+		return ctx.Err() == nil, 0, fmt.Errorf("error sending request: %v", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad HTTP response code: %s", resp.Status)
+		return c.retry.retryable(resp.StatusCode), retryAfter(resp), fmt.Errorf("bad HTTP response code: %s", resp.Status)
 	}
-	return nil
+	return false, 0, nil
 }
 
+// Query implements StorageClient.
 func (c *solrClient) Query(q, cj, fl string) ([]byte, error) {
+	return c.QueryContext(context.Background(), q, cj, fl)
+}
+
+// QueryContext implements StorageClient.
+func (c *solrClient) QueryContext(ctx context.Context, q, cj, fl string) ([]byte, error) {
+	return c.QueryWithParams(ctx, QueryParams{Q: q, CJ: cj, FL: fl})
+}
+
+// QueryWithParams implements StorageClient.
+func (c *solrClient) QueryWithParams(ctx context.Context, params QueryParams) ([]byte, error) {
 	u := *c.url
 	u.Path = path.Join(c.url.Path, "/select")
 	qs := u.Query()
-	qs.Set("q", q)
-	qs.Set("cj", cj)
-	qs.Set("fl", fl)
+	qs.Set("q", params.Q)
+	qs.Set("cj", params.CJ)
+	qs.Set("fl", params.FL)
+	if params.CF != "" {
+		qs.Set("cf", params.CF)
+	}
 	qs.Set("wt", "json")
 	u.RawQuery = qs.Encode()
 
-	resp, err := c.httpClient.Get(u.String())
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("error sending request: %v", err)
 	}
@@ -107,6 +194,75 @@ func (c *solrClient) Query(q, cj, fl string) ([]byte, error) {
 	return body, nil
 }
 
+// solrPageResponse models a page of a Solr select response using a cursor.
+type solrPageResponse struct {
+	Response struct {
+		Docs []map[string]interface{} `json:"docs"`
+	} `json:"response"`
+	NextCursorMark string `json:"nextCursorMark"`
+}
+
+// QueryPage implements StorageClient. It pages through the results using a Solr
+// cursor sorted by the document id. Joins and functions are evaluated per page,
+// which is why SeriesIterator rejects them.
+func (c *solrClient) QueryPage(ctx context.Context, params QueryParams, cursor string, rows int) (*ResultPage, error) {
+	if cursor == "" {
+		cursor = "*"
+	}
+	u := *c.url
+	u.Path = path.Join(c.url.Path, "/select")
+	qs := u.Query()
+	qs.Set("q", params.Q)
+	qs.Set("cj", params.CJ)
+	qs.Set("fl", params.FL)
+	if params.CF != "" {
+		qs.Set("cf", params.CF)
+	}
+	qs.Set("rows", strconv.Itoa(rows))
+	qs.Set("sort", "id asc")
+	qs.Set("cursorMark", cursor)
+	qs.Set("wt", "json")
+	u.RawQuery = qs.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad HTTP response code: %s", resp.Status)
+	}
+
+	var page solrPageResponse
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&page); err != nil {
+		return nil, fmt.Errorf("error unmarshalling query response: %v", err)
+	}
+
+	result := &ResultPage{Docs: page.Response.Docs}
+	// Solr returns the given cursor again once all documents have been read.
+	if page.NextCursorMark != cursor && len(page.Response.Docs) > 0 {
+		result.Cursor = page.NextCursorMark
+	}
+	return result, nil
+}
+
+// CloseCursor implements StorageClient. Solr cursors are stateless, so there is
+// nothing to release.
+func (c *solrClient) CloseCursor(ctx context.Context, cursor string) error {
+	return nil
+}
+
 func (c *solrClient) NeedPostfixOnDynamicField() bool {
 	return true
 }
+
+func (c *solrClient) DynamicFieldName(name string, value interface{}) string {
+	return PostfixFieldName(name, value)
+}
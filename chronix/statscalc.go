@@ -2,20 +2,159 @@ package chronix
 
 import (
 	"errors"
-	"math/big"
+	"fmt"
 	"math"
+	"math/big"
+	"sort"
+)
+
+// A Statistic selects an optional statistic that is stored on every chunk in
+// addition to the count, min, max, avg and timespan. Statistics can be combined,
+// e.g. StatSum | StatStdDev.
+type Statistic uint
+
+const (
+	// StatSum is the sum of the values (field stats_sum).
+	StatSum Statistic = 1 << iota
+	// StatStdDev is the population standard deviation of the values (field stats_stddev).
+	StatStdDev
+	// StatFirst is the value of the first point (field stats_first).
+	StatFirst
+	// StatLast is the value of the last point (field stats_last).
+	StatLast
+	// StatNaNCount is the number of NaN values (field stats_nan_count).
+	StatNaNCount
+	// StatP50 is the median of the values (field stats_p50).
+	StatP50
+	// StatP90 is the 90th percentile of the values (field stats_p90).
+	StatP90
+	// StatP99 is the 99th percentile of the values (field stats_p99).
+	StatP99
+	// StatInfCount is the number of positive or negative infinite values (field stats_inf_count).
+	StatInfCount
+
+	// StatAll selects all optional statistics.
+	StatAll = StatSum | StatStdDev | StatFirst | StatLast | StatNaNCount | StatP50 | StatP90 | StatP99 | StatInfCount
 )
 
+// A SpecialValuePolicy defines how NaN and infinite values are treated by the statistics.
+// NaN values are never part of the values statistics are calculated from. Statistics
+// that are not finite, e.g. the min of a chunk of NaN values only, are stored as null.
+type SpecialValuePolicy int
+
+const (
+	// ExcludeSpecialValues calculates the statistics from the finite values only.
+	ExcludeSpecialValues SpecialValuePolicy = iota
+	// IncludeInfinity includes infinite values in the statistics, so that e.g. the
+	// max of a chunk with a +Inf value is +Inf and therefore stored as null.
+	IncludeInfinity
+)
+
+// statsOptions configures the calculation of statistics.
+type statsOptions struct {
+	// extra selects the optional statistics.
+	extra Statistic
+	// special defines how NaN and infinite values are treated.
+	special SpecialValuePolicy
+}
+
+// A StatsCalculator calculates statistics of a chunk that are stored in the chunk
+// document, e.g. the number of counter resets or the rate of a counter.
+type StatsCalculator interface {
+	// Calculate returns the statistics of a chunk by their field names, e.g. "stats_rate".
+	// Values may be numbers, strings or booleans. The field names are mapped by the
+	// storage (see StorageClient.DynamicFieldName), and values that are not finite are
+	// stored as null.
+	Calculate(chunk *TimeSeries) (map[string]interface{}, error)
+}
+
+// The StatsCalculatorFunc type is an adapter to use ordinary functions as a StatsCalculator.
+type StatsCalculatorFunc func(chunk *TimeSeries) (map[string]interface{}, error)
+
+// Calculate calls f(chunk).
+func (f StatsCalculatorFunc) Calculate(chunk *TimeSeries) (map[string]interface{}, error) {
+	return f(chunk)
+}
+
+// builtinStats calculates the count, min, max, avg and timespan of a chunk and the
+// selected optional statistics.
+type builtinStats statsOptions
+
+func (b builtinStats) Calculate(chunk *TimeSeries) (map[string]interface{}, error) {
+	stats, err := calculateStats(chunk, statsOptions(b))
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{
+		"stats_timespan": stats.timespan,
+		"stats_count": stats.count,
+		"stats_min": stats.min,
+		"stats_max": stats.max,
+		"stats_avg": stats.avg,
+	}
+
+	optional := []struct {
+		statistic Statistic
+		field     string
+		value     interface{}
+	}{
+		{StatSum, "stats_sum", stats.sum},
+		{StatStdDev, "stats_stddev", stats.stddev},
+		{StatFirst, "stats_first", stats.first},
+		{StatLast, "stats_last", stats.last},
+		{StatNaNCount, "stats_nan_count", stats.nanCount},
+		{StatInfCount, "stats_inf_count", stats.infCount},
+		{StatP50, "stats_p50", stats.p50},
+		{StatP90, "stats_p90", stats.p90},
+		{StatP99, "stats_p99", stats.p99},
+	}
+	for _, o := range optional {
+		if b.extra&o.statistic != 0 {
+			fields[o.field] = o.value
+		}
+	}
+	return fields, nil
+}
+
+// statField returns the field name and value to store for a statistic. The
+// field name is mapped by the storage by the type of the calculated value, see
+// StorageClient.DynamicFieldName.
+func statField(storage StorageClient, name string, value interface{}) (string, interface{}, error) {
+	stored := value
+	switch v := value.(type) {
+	case nil, int, int32, int64, uint, uint32, uint64, string, bool:
+	case float32:
+		stored = statValue(float64(v))
+	case float64:
+		stored = statValue(v)
+	default:
+		return "", nil, fmt.Errorf("unsupported type %T of statistic %s", value, name)
+	}
+	return storage.DynamicFieldName(name, value), stored, nil
+}
+
 type stats struct {
 	count int64
 	min float64
 	max float64
 	avg float64
 	timespan int64
+
+	sum float64
+	stddev float64
+	first float64
+	last float64
+	nanCount int64
+	infCount int64
+	p50 float64
+	p90 float64
+	p99 float64
 }
 
-// Calculates the statistics for one TimeSeries
-func calculateStats(timeSeries *TimeSeries) (stats, error) {
+// Calculates the statistics for one TimeSeries. Statistics without values to
+// calculate them from are NaN.
+func calculateStats(timeSeries *TimeSeries, opts statsOptions) (stats, error) {
 	points := &timeSeries.Points
 
 	result := stats{}
@@ -25,28 +164,129 @@ func calculateStats(timeSeries *TimeSeries) (stats, error) {
 	}
 
 	result.count = int64(len(*points))
-	result.min = (*points)[0].Value
-	result.max = (*points)[0].Value
+	result.min = math.NaN()
+	result.max = math.NaN()
 
 	var sum = big.NewFloat(0)
 	var number int64 = 0
+	var posInf, negInf bool
 
 	for _, point := range *points {
 		if math.IsNaN(point.Value) {
+			result.nanCount++
+		}
+		if math.IsInf(point.Value, 0) {
+			result.infCount++
+		}
+		if !opts.special.includes(point.Value) {
 			continue
 		}
-		if point.Value > result.max {
+		if number == 0 || point.Value > result.max {
 			result.max = point.Value
-		} else if point.Value < result.min {
+		}
+		if number == 0 || point.Value < result.min {
 			result.min = point.Value
 		}
 
 		number++
-		sum.Add(sum, big.NewFloat(point.Value))
+		switch {
+		case math.IsInf(point.Value, 1):
+			posInf = true
+		case math.IsInf(point.Value, -1):
+			negInf = true
+		default:
+			sum.Add(sum, big.NewFloat(point.Value))
+		}
 	}
 
-	result.avg, _ = sum.Quo(sum, big.NewFloat(float64(number))).Float64()
 	result.timespan = (*points)[len(*points) - 1].Timestamp - (*points)[0].Timestamp
+	result.first = (*points)[0].Value
+	result.last = (*points)[len(*points) - 1].Value
+
+	if number == 0 {
+		result.avg = math.NaN()
+		result.sum = math.NaN()
+		result.stddev = math.NaN()
+		result.p50, result.p90, result.p99 = math.NaN(), math.NaN(), math.NaN()
+		return result, nil
+	}
+
+	switch {
+	case posInf && negInf:
+		// Sums of infinite values of different signs are undefined.
+		result.sum, result.avg = math.NaN(), math.NaN()
+	case posInf:
+		result.sum, result.avg = math.Inf(1), math.Inf(1)
+	case negInf:
+		result.sum, result.avg = math.Inf(-1), math.Inf(-1)
+	default:
+		result.sum, _ = sum.Float64()
+		result.avg, _ = sum.Quo(sum, big.NewFloat(float64(number))).Float64()
+	}
+
+	if opts.extra&StatStdDev != 0 {
+		result.stddev = stddev(*points, result.avg, number, opts.special)
+	}
+	if opts.extra&(StatP50|StatP90|StatP99) != 0 {
+		values := sortedValues(*points, opts.special)
+		result.p50 = percentile(values, 0.5)
+		result.p90 = percentile(values, 0.9)
+		result.p99 = percentile(values, 0.99)
+	}
 
 	return result, nil
 }
+
+// includes reports whether a value is part of the values statistics are calculated from.
+func (p SpecialValuePolicy) includes(v float64) bool {
+	if math.IsNaN(v) {
+		return false
+	}
+	return p == IncludeInfinity || !math.IsInf(v, 0)
+}
+
+// stddev calculates the population standard deviation of the included values.
+func stddev(points []Point, avg float64, number int64, special SpecialValuePolicy) float64 {
+	var sumSquares float64
+	for _, p := range points {
+		if !special.includes(p.Value) {
+			continue
+		}
+		d := p.Value - avg
+		sumSquares += d * d
+	}
+	return math.Sqrt(sumSquares / float64(number))
+}
+
+// sortedValues returns the sorted included values of the points.
+func sortedValues(points []Point, special SpecialValuePolicy) []float64 {
+	values := make([]float64, 0, len(points))
+	for _, p := range points {
+		if special.includes(p.Value) {
+			values = append(values, p.Value)
+		}
+	}
+	sort.Float64s(values)
+	return values
+}
+
+// statValue returns the value to store for a statistic. Values that are not finite
+// are stored as null, as JSON cannot represent them.
+func statValue(v float64) interface{} {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return v
+}
+
+// percentile returns the p-percentile of the sorted values using the nearest-rank method.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	rank := int(math.Ceil(p*float64(len(values)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return values[rank]
+}
@@ -1,6 +1,11 @@
 package chronix
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 	"math"
 )
@@ -12,7 +17,7 @@ func TestCalculateStats(t *testing.T) {
 	series.Points = []Point{{Timestamp: 123, Value: 1.5}, {Timestamp: 153, Value:1.9}, {Timestamp: 200, Value:0.2}}
 
 	// when:
-	stats, err := calculateStats(&series)
+	stats, err := calculateStats(&series, statsOptions{})
 
 	// then:
 	if err != nil {
@@ -46,7 +51,7 @@ func TestWithBigFloat(t *testing.T) {
 	series.Points = []Point{{Timestamp: 1, Value: math.MaxFloat64}, {Timestamp: 2, Value:math.MaxFloat64}}
 
 	// when:
-	stats, err := calculateStats(&series)
+	stats, err := calculateStats(&series, statsOptions{})
 	// then:
 	if err != nil {
 		t.Fatal("Failed to calculate Stats", err)
@@ -63,7 +68,7 @@ func TestCalculateStatsWithEmptyPoints(t *testing.T) {
 	series.Points = []Point{}
 
 	// when:
-	_, err := calculateStats(&series)
+	_, err := calculateStats(&series, statsOptions{})
 
 	// then:
 	if err == nil {
@@ -80,7 +85,7 @@ func TestCalculateStatsWithNaNValue(t *testing.T) {
 	series := TimeSeries{ Name: "Test", Type: "metric", Attributes: map[string]string{ "host": "node0"}}
 	series.Points = []Point{{Timestamp: 1, Value: math.NaN()}, {Timestamp: 2, Value:1}}
 	// when:
-	stats, err := calculateStats(&series)
+	stats, err := calculateStats(&series, statsOptions{})
 	// then:
 	if err != nil {
 		t.Fatal("Failed to calculate Stats", err)
@@ -90,3 +95,285 @@ func TestCalculateStatsWithNaNValue(t *testing.T) {
 		t.Error("Expected the avg to be 1, got ", stats.avg)
 	}
 }
+
+func TestCalculateOptionalStats(t *testing.T) {
+	// given:
+	series := TimeSeries{ Name: "Test", Type: "metric", Attributes: map[string]string{ "host": "node0"}}
+	for i := 1; i <= 100; i++ {
+		series.Points = append(series.Points, Point{Timestamp: int64(i), Value: float64(i)})
+	}
+	series.Points[0].Value = math.NaN()
+
+	// when:
+	stats, err := calculateStats(&series, statsOptions{extra: StatAll})
+
+	// then:
+	if err != nil {
+		t.Fatal("Failed to calculate Stats", err)
+	}
+
+	if stats.sum != 5049 {
+		t.Error("Expected sum=5049, got ", stats.sum)
+	}
+
+	if math.Abs(stats.stddev - 28.577380332470412) > 1e-9 {
+		t.Error("Expected stddev=28.577, got ", stats.stddev)
+	}
+
+	if !math.IsNaN(stats.first) || stats.last != 100 {
+		t.Error("Expected first=NaN and last=100, got ", stats.first, stats.last)
+	}
+
+	if stats.nanCount != 1 {
+		t.Error("Expected nanCount=1, got ", stats.nanCount)
+	}
+
+	if stats.p50 != 51 || stats.p90 != 91 || stats.p99 != 100 {
+		t.Error("Expected p50=51, p90=91, p99=100, got ", stats.p50, stats.p90, stats.p99)
+	}
+}
+
+func TestStoreWithOptionalStats(t *testing.T) {
+	// given:
+	storage := &recordingStorage{}
+	c := NewWithOptions(storage, Options{CreateStatistics: true, Statistics: StatSum | StatP99})
+	series := []*TimeSeries{{ Name: "Test", Type: "metric", Points: pointsFrom(1, 10)}}
+
+	// when:
+	if err := c.Store(series, false, 0); err != nil {
+		t.Fatal("Failed to store series", err)
+	}
+
+	// then:
+	doc := storage.documents()[0]
+	if doc["stats_sum_f"] != float64(55) || doc["stats_p99_f"] != float64(10) {
+		t.Error("Expected the selected statistics, got ", doc)
+	}
+	if _, ok := doc["stats_stddev_f"]; ok {
+		t.Error("Expected no unselected statistics, got ", doc)
+	}
+}
+
+func TestCalculateStatsWithSpecialValues(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+
+	tests := []struct {
+		values  []float64
+		special SpecialValuePolicy
+		min     float64
+		max     float64
+		avg     float64
+	}{
+		{values: []float64{nan, 3, 1, 2}, min: 1, max: 3, avg: 2},
+		{values: []float64{2, 1}, min: 1, max: 2, avg: 1.5},
+		{values: []float64{5}, min: 5, max: 5, avg: 5},
+		{values: []float64{nan, nan}, min: nan, max: nan, avg: nan},
+		{values: []float64{inf, 1, -inf, 3}, min: 1, max: 3, avg: 2},
+		{values: []float64{inf, 1, 3}, special: IncludeInfinity, min: 1, max: inf, avg: inf},
+		{values: []float64{inf, -inf, nan}, special: IncludeInfinity, min: -inf, max: inf, avg: nan},
+	}
+
+	for i, test := range tests {
+		series := TimeSeries{Name: "Test", Type: "metric"}
+		for j, v := range test.values {
+			series.Points = append(series.Points, Point{Timestamp: int64(j), Value: v})
+		}
+
+		stats, err := calculateStats(&series, statsOptions{extra: StatAll, special: test.special})
+		if err != nil {
+			t.Fatalf("%d. Failed to calculate Stats: %v", i, err)
+		}
+		if !sameFloat(stats.min, test.min) || !sameFloat(stats.max, test.max) || !sameFloat(stats.avg, test.avg) {
+			t.Errorf("%d. Expected min=%v, max=%v, avg=%v, got %v, %v, %v",
+				i, test.min, test.max, test.avg, stats.min, stats.max, stats.avg)
+		}
+		if stats.count != int64(len(test.values)) {
+			t.Errorf("%d. Expected count=%d, got %d", i, len(test.values), stats.count)
+		}
+	}
+}
+
+func sameFloat(a, b float64) bool {
+	return a == b || math.IsNaN(a) && math.IsNaN(b)
+}
+
+func TestStoreWithUndefinedStats(t *testing.T) {
+	// given:
+	storage := &recordingStorage{}
+	c := NewWithOptions(storage, Options{CreateStatistics: true, Statistics: StatAll})
+	series := []*TimeSeries{{ Name: "Test", Type: "metric", Points: []Point{
+		{Timestamp: 1, Value: math.NaN()}, {Timestamp: 2, Value: math.Inf(-1)},
+	}}}
+
+	// when:
+	if err := c.Store(series, false, 0); err != nil {
+		t.Fatal("Failed to store series", err)
+	}
+
+	// then:
+	doc := storage.documents()[0]
+	for _, field := range []string{"stats_min_f", "stats_max_f", "stats_avg_f", "stats_sum_f", "stats_p50_f", "stats_first_f"} {
+		if v, ok := doc[field]; !ok || v != nil {
+			t.Errorf("Expected %s to be null, got %v", field, v)
+		}
+	}
+	if doc["stats_nan_count_f"] != int64(1) || doc["stats_inf_count_f"] != int64(1) || doc["stats_count_f"] != int64(2) {
+		t.Error("Expected the special values to be counted, got ", doc)
+	}
+	if _, err := json.Marshal(doc); err != nil {
+		t.Error("Expected the document to be marshallable, got ", err)
+	}
+}
+
+// counterStats calculates the number of counter resets and the per-second rate of a counter.
+var counterStats = StatsCalculatorFunc(func(chunk *TimeSeries) (map[string]interface{}, error) {
+	var resets int64
+	var increase float64
+	for i := 1; i < len(chunk.Points); i++ {
+		delta := chunk.Points[i].Value - chunk.Points[i-1].Value
+		if delta < 0 {
+			resets++
+			delta = chunk.Points[i].Value
+		}
+		increase += delta
+	}
+	seconds := float64(chunk.Points[len(chunk.Points)-1].Timestamp-chunk.Points[0].Timestamp) / 1000
+	return map[string]interface{}{
+		"stats_counter_resets": resets,
+		"stats_rate":           increase / seconds,
+		"stats_unit":           "requests",
+		"stats_reset":          resets > 0,
+	}, nil
+})
+
+func TestStoreWithStatsCalculator(t *testing.T) {
+	// given:
+	storage := &recordingStorage{}
+	c := NewWithOptions(storage, Options{CreateStatistics: true, StatsCalculators: []StatsCalculator{counterStats}})
+	series := []*TimeSeries{{ Name: "Test", Type: "metric", Points: []Point{
+		{Timestamp: 1000, Value: 10}, {Timestamp: 2000, Value: 20}, {Timestamp: 3000, Value: 5},
+	}}}
+
+	// when:
+	if err := c.Store(series, false, 0); err != nil {
+		t.Fatal("Failed to store series", err)
+	}
+
+	// then:
+	doc := storage.documents()[0]
+	want := map[string]interface{}{
+		"stats_counter_resets_f": int64(1),
+		"stats_rate_f":           7.5,
+		"stats_unit_s":           "requests",
+		"stats_reset_b":          true,
+		"stats_count_f":          int64(3),
+	}
+	for field, value := range want {
+		if doc[field] != value {
+			t.Errorf("Expected %s=%v, got %v", field, value, doc[field])
+		}
+	}
+}
+
+// A StorageClient whose schema stores integers in long fields ("_l").
+type longFieldStorage struct {
+	recordingStorage
+}
+
+func (s *longFieldStorage) DynamicFieldName(name string, value interface{}) string {
+	if _, ok := value.(int64); ok {
+		return name + "_l"
+	}
+	return PostfixFieldName(name, value)
+}
+
+func TestStoreWithStatsCalculatorUsesStorageFieldNames(t *testing.T) {
+	// given:
+	storage := &longFieldStorage{}
+	c := NewWithOptions(storage, Options{StatsCalculators: []StatsCalculator{counterStats}})
+	series := []*TimeSeries{{ Name: "Test", Type: "metric", Attributes: map[string]string{"host": "a"}, Points: []Point{
+		{Timestamp: 1000, Value: 10}, {Timestamp: 2000, Value: 5},
+	}}}
+
+	// when:
+	if err := c.Store(series, false, 0); err != nil {
+		t.Fatal("Failed to store series", err)
+	}
+
+	// then:
+	doc := storage.documents()[0]
+	want := map[string]interface{}{
+		"stats_counter_resets_l": int64(1),
+		"stats_rate_f":           5.0,
+		"host_s":                 "a",
+	}
+	for field, value := range want {
+		if doc[field] != value {
+			t.Errorf("Expected %s=%v, got %v", field, value, doc[field])
+		}
+	}
+}
+
+func TestStoreWithStatsCalculatorOnSolr(t *testing.T) {
+	// given:
+	var docs []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dec := json.NewDecoder(r.Body)
+		dec.UseNumber()
+		if err := dec.Decode(&docs); err != nil {
+			t.Fatal("Error unmarshalling body:", err)
+		}
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL + "/solr/chronix")
+	if err != nil {
+		t.Fatal("Error parsing Solr URL:", err)
+	}
+	c := NewWithOptions(NewSolrStorage(u, nil), Options{StatsCalculators: []StatsCalculator{counterStats}})
+	series := []*TimeSeries{{ Name: "Test", Type: "metric", Points: []Point{
+		{Timestamp: 1000, Value: 10}, {Timestamp: 2000, Value: 5},
+	}}}
+
+	// when:
+	if err := c.Store(series, false, 0); err != nil {
+		t.Fatal("Failed to store series", err)
+	}
+
+	// then:
+	if len(docs) != 1 {
+		t.Fatalf("Expected one document, got %d", len(docs))
+	}
+	if got := docs[0]["stats_counter_resets_f"]; got != json.Number("1") {
+		t.Errorf("Expected the integer statistic in a float field, got %v", got)
+	}
+	if _, ok := docs[0]["stats_counter_resets"]; ok {
+		t.Error("Expected no field without postfix")
+	}
+}
+
+func TestStoreWithFailingStatsCalculator(t *testing.T) {
+	tests := []StatsCalculator{
+		StatsCalculatorFunc(func(chunk *TimeSeries) (map[string]interface{}, error) {
+			return nil, errors.New("failed")
+		}),
+		StatsCalculatorFunc(func(chunk *TimeSeries) (map[string]interface{}, error) {
+			return map[string]interface{}{"stats_points": chunk.Points}, nil
+		}),
+		StatsCalculatorFunc(func(chunk *TimeSeries) (map[string]interface{}, error) {
+			return map[string]interface{}{"stats_count": 1}, nil
+		}),
+	}
+
+	for i, calculator := range tests {
+		storage := &recordingStorage{}
+		c := NewWithOptions(storage, Options{CreateStatistics: true, StatsCalculators: []StatsCalculator{calculator}})
+		series := []*TimeSeries{{ Name: "Test", Type: "metric", Points: pointsFrom(1, 3)}}
+
+		if err := c.Store(series, false, 0); err == nil {
+			t.Errorf("%d. Expected an error", i)
+		}
+		if len(storage.documents()) != 0 {
+			t.Errorf("%d. Expected no documents to be stored", i)
+		}
+	}
+}
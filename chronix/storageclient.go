@@ -1,12 +1,59 @@
 package chronix
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // A StorageClient allows updating documents in Solr.
 type StorageClient interface {
 	Update(data []map[string]interface{}, commit bool, commitWithin time.Duration) error
+	// UpdateContext is like Update but uses ctx for the request.
+	UpdateContext(ctx context.Context, data []map[string]interface{}, commit bool, commitWithin time.Duration) error
 	// TODO: Return a more interpreted result on the Solr level.
 	Query(q, fq, fl string) ([]byte, error)
+	// QueryContext is like Query but uses ctx for the request.
+	QueryContext(ctx context.Context, q, fq, fl string) ([]byte, error)
+	// QueryWithParams is like QueryContext but takes all query parameters including
+	// the Chronix functions to evaluate on the server.
+	QueryWithParams(ctx context.Context, params QueryParams) ([]byte, error)
+	// QueryPage returns one page of at most rows documents matching the query. The
+	// cursor of the first page is empty, the following pages are requested with the
+	// cursor returned with the previous page. The returned cursor is empty after the
+	// last page.
+	QueryPage(ctx context.Context, params QueryParams, cursor string, rows int) (*ResultPage, error)
+	// CloseCursor releases the resources held by the storage for a cursor returned by
+	// QueryPage if the remaining pages are not requested.
+	CloseCursor(ctx context.Context, cursor string) error
 
+	// NeedPostfixOnDynamicField reports whether the names of dynamic fields need the
+	// postfix of their type, as required by the Chronix Solr schema: "_s" for
+	// strings (including all attributes), "_f" for numbers and "_b" for booleans.
+	// Attributes are read back by their "_s" postfix.
 	NeedPostfixOnDynamicField() bool
+	// DynamicFieldName returns the name of the field storing a dynamic field, i.e.
+	// an attribute or a statistic, with the given value according to the rules of
+	// the schema, e.g. PostfixFieldName for the Chronix Solr schema.
+	DynamicFieldName(name string, value interface{}) string
+}
+
+// PostfixFieldName returns the name of a dynamic field in the Chronix Solr schema:
+// the name with the postfix "_s" for strings, "_b" for booleans and "_f" for numbers
+// and other values.
+func PostfixFieldName(name string, value interface{}) string {
+	switch value.(type) {
+	case string:
+		return name + "_s"
+	case bool:
+		return name + "_b"
+	}
+	return name + "_f"
+}
+
+// A ResultPage is one page of the documents matching a query.
+type ResultPage struct {
+	// Docs are the documents of the page.
+	Docs []map[string]interface{}
+	// Cursor is the cursor of the next page. It is empty after the last page.
+	Cursor string
 }
@@ -6,6 +6,18 @@ type TimeSeries struct {
 	Type       string
 	Attributes map[string]string
 	Points     []Point
+	// DDCThreshold overrides the date-delta-compaction threshold (in milliseconds)
+	// of the client for this series, e.g. OverrideDDC(0) stores exact timestamps.
+	// If it is nil, the threshold of the client is used.
+	DDCThreshold *uint32
+	// Functions are the results of the Chronix functions of a query. They are
+	// ignored when storing series.
+	Functions []FunctionResult
+}
+
+// OverrideDDC returns a date-delta-compaction threshold for TimeSeries.DDCThreshold.
+func OverrideDDC(threshold uint32) *uint32 {
+	return &threshold
 }
 
 // A Point models a Chronix time series sample.
@@ -0,0 +1,171 @@
+package chronix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// A DuplicatePolicy defines how Store handles points of a series with the same timestamp.
+type DuplicatePolicy int
+
+const (
+	// DuplicatesKeepFirst keeps the first of the points with the same timestamp.
+	DuplicatesKeepFirst DuplicatePolicy = iota
+	// DuplicatesKeepLast keeps the last of the points with the same timestamp.
+	DuplicatesKeepLast
+	// DuplicatesError rejects series with points with the same timestamp.
+	DuplicatesError
+)
+
+// ErrEmptyName is the cause of an InvalidSeriesError for a series without a name.
+var ErrEmptyName = errors.New("series has no name")
+
+// An InvalidSeriesError is returned by Store for a series that cannot be stored.
+type InvalidSeriesError struct {
+	// Name is the name of the series.
+	Name string
+	// Attributes are the attributes of the series.
+	Attributes map[string]string
+	// Err is the cause.
+	Err error
+}
+
+func (e *InvalidSeriesError) Error() string {
+	return fmt.Sprintf("invalid series '%s' %v: %v", e.Name, e.Attributes, e.Err)
+}
+
+// Unwrap returns the cause, so that it can be checked with errors.Is and errors.As.
+func (e *InvalidSeriesError) Unwrap() error {
+	return e.Err
+}
+
+// A ReservedAttributeError is the cause of an InvalidSeriesError for an attribute
+// that would overwrite a field of the chunk document.
+type ReservedAttributeError struct {
+	// Attribute is the name of the attribute.
+	Attribute string
+}
+
+func (e *ReservedAttributeError) Error() string {
+	return fmt.Sprintf("reserved attribute name '%s'", e.Attribute)
+}
+
+// checkAttributes rejects attributes of a series that would overwrite the fields
+// of the document, which is the case for storages without a postfix on dynamic
+// fields (see StorageClient.NeedPostfixOnDynamicField).
+func checkAttributes(ts *TimeSeries, postfixOnDynamicField bool) error {
+	if postfixOnDynamicField {
+		return nil
+	}
+	for k := range ts.Attributes {
+		if reservedFields[k] {
+			return &InvalidSeriesError{
+				Name:       ts.Name,
+				Attributes: ts.Attributes,
+				Err:        &ReservedAttributeError{Attribute: k},
+			}
+		}
+	}
+	return nil
+}
+
+// StoreEach stores the series through the client like StoreContext, but an invalid
+// series does not fail the others. If renamePrefix is not empty, reserved
+// attributes (see ReservedAttributeError) are renamed by prefixing them, e.g.
+// "type" becomes "exported_type" for the prefix "exported_". The other invalid
+// series are not stored; their errors are returned with the error of the storage.
+func StoreEach(ctx context.Context, c Client, series []*TimeSeries, commit bool, commitWithin time.Duration, renamePrefix string) ([]*InvalidSeriesError, error) {
+	var invalid []*InvalidSeriesError
+	series = append([]*TimeSeries(nil), series...)
+	for len(series) > 0 {
+		err := c.StoreContext(ctx, series, commit, commitWithin)
+		e, ok := err.(*InvalidSeriesError)
+		if !ok {
+			return invalid, err
+		}
+		i := indexOfSeries(series, e)
+		if i < 0 {
+			return invalid, err
+		}
+		if r, ok := e.Err.(*ReservedAttributeError); ok && renamePrefix != "" {
+			renamed := *series[i]
+			renamed.Attributes = copyAttributes(renamed.Attributes)
+			renamed.Attributes[renamePrefix+r.Attribute] = renamed.Attributes[r.Attribute]
+			delete(renamed.Attributes, r.Attribute)
+			series[i] = &renamed
+			continue
+		}
+		invalid = append(invalid, e)
+		series = append(series[:i], series[i+1:]...)
+	}
+	return invalid, nil
+}
+
+// indexOfSeries returns the index of the series an InvalidSeriesError is about, or -1.
+func indexOfSeries(series []*TimeSeries, e *InvalidSeriesError) int {
+	for i, ts := range series {
+		if ts.Name == e.Name && reflect.DeepEqual(ts.Attributes, e.Attributes) {
+			return i
+		}
+	}
+	return -1
+}
+
+type byTimestamp []Point
+
+func (p byTimestamp) Len() int           { return len(p) }
+func (p byTimestamp) Less(i, j int) bool { return p[i].Timestamp < p[j].Timestamp }
+func (p byTimestamp) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// normalize validates a series and returns it with the points sorted by their
+// timestamps and duplicate timestamps resolved by the policy. The given series
+// is not modified; it is returned as is if it is already normalized.
+func normalize(ts *TimeSeries, duplicates DuplicatePolicy) (*TimeSeries, error) {
+	if ts.Name == "" {
+		return nil, &InvalidSeriesError{Name: ts.Name, Attributes: ts.Attributes, Err: ErrEmptyName}
+	}
+
+	normalized := true
+	for i := 1; i < len(ts.Points); i++ {
+		if ts.Points[i].Timestamp <= ts.Points[i-1].Timestamp {
+			normalized = false
+			break
+		}
+	}
+	if normalized {
+		return ts, nil
+	}
+
+	points := make([]Point, len(ts.Points))
+	copy(points, ts.Points)
+	// The sort is stable to keep the order of points with the same timestamp.
+	sort.Stable(byTimestamp(points))
+
+	unique := points[:1]
+	for _, p := range points[1:] {
+		last := &unique[len(unique)-1]
+		if p.Timestamp != last.Timestamp {
+			unique = append(unique, p)
+			continue
+		}
+		switch duplicates {
+		case DuplicatesKeepFirst:
+		case DuplicatesKeepLast:
+			*last = p
+		default:
+			return nil, &InvalidSeriesError{
+				Name:       ts.Name,
+				Attributes: ts.Attributes,
+				Err:        fmt.Errorf("duplicate timestamp %d", p.Timestamp),
+			}
+		}
+	}
+
+	result := *ts
+	result.Points = unique
+	return &result, nil
+}
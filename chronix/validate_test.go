@@ -0,0 +1,167 @@
+package chronix
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	unsorted := []Point{
+		{Timestamp: 3, Value: 1},
+		{Timestamp: 1, Value: 2},
+		{Timestamp: 3, Value: 3},
+		{Timestamp: 2, Value: 4},
+	}
+
+	tests := []struct {
+		policy DuplicatePolicy
+		want   []Point
+	}{
+		{
+			policy: DuplicatesKeepFirst,
+			want:   []Point{{Timestamp: 1, Value: 2}, {Timestamp: 2, Value: 4}, {Timestamp: 3, Value: 1}},
+		},
+		{
+			policy: DuplicatesKeepLast,
+			want:   []Point{{Timestamp: 1, Value: 2}, {Timestamp: 2, Value: 4}, {Timestamp: 3, Value: 3}},
+		},
+	}
+
+	for i, test := range tests {
+		ts := &TimeSeries{Name: "test", Points: unsorted}
+		got, err := normalize(ts, test.policy)
+		if err != nil {
+			t.Fatalf("%d. Error normalizing series: %v", i, err)
+		}
+		if !reflect.DeepEqual(got.Points, test.want) {
+			t.Errorf("%d. Unexpected points. Want %v, got %v", i, test.want, got.Points)
+		}
+		if ts.Points[0].Timestamp != 3 {
+			t.Errorf("%d. The given series was modified", i)
+		}
+	}
+}
+
+func TestNormalizeErrors(t *testing.T) {
+	tests := []*TimeSeries{
+		{Points: pointsFrom(1, 3)},
+		{Name: "test", Points: []Point{{Timestamp: 1}, {Timestamp: 1}}},
+	}
+	for i, ts := range tests {
+		if _, err := normalize(ts, DuplicatesError); err == nil {
+			t.Errorf("%d. Expected an error", i)
+		} else if _, ok := err.(*InvalidSeriesError); !ok {
+			t.Errorf("%d. Expected an *InvalidSeriesError, got %v", i, err)
+		}
+	}
+
+	sorted := &TimeSeries{Name: "test", Points: pointsFrom(1, 3)}
+	if got, err := normalize(sorted, DuplicatesError); err != nil || got != sorted {
+		t.Errorf("Expected the sorted series to be returned as is, got %v, %v", got, err)
+	}
+}
+
+func TestStoreNormalizesPoints(t *testing.T) {
+	storage := &recordingStorage{}
+	c := New(storage)
+
+	series := []*TimeSeries{{
+		Name:   "test",
+		Type:   "metric",
+		Points: []Point{{Timestamp: 300, Value: 1}, {Timestamp: 100, Value: 2}, {Timestamp: 200, Value: 3}},
+	}}
+	if err := c.Store(series, false, 0); err != nil {
+		t.Fatal("Error storing series:", err)
+	}
+	docs := storage.documents()
+	if len(docs) != 1 || docs[0]["start"] != int64(100) || docs[0]["end"] != int64(300) {
+		t.Fatalf("Unexpected documents %v", docs)
+	}
+
+	err := c.Store([]*TimeSeries{{Type: "metric", Points: pointsFrom(1, 3)}}, false, 0)
+	if e, ok := err.(*InvalidSeriesError); !ok || e.Err != ErrEmptyName {
+		t.Fatalf("Expected an empty name error, got %v", err)
+	}
+	if !errors.Is(err, ErrEmptyName) {
+		t.Error("Expected the error to wrap ErrEmptyName")
+	}
+}
+
+func TestStoreRejectsReservedAttributes(t *testing.T) {
+	series := []*TimeSeries{{
+		Name:       "test",
+		Type:       "metric",
+		Attributes: map[string]string{"type": "gauge"},
+		Points:     pointsFrom(1, 3),
+	}}
+
+	// Attributes get a postfix, so they cannot overwrite the type.
+	if err := New(&recordingStorage{}).Store(series, false, 0); err != nil {
+		t.Fatal("Error storing series:", err)
+	}
+
+	storage := &noPostfixStorage{}
+	err := New(storage).Store(series, false, 0)
+	if _, ok := err.(*InvalidSeriesError); !ok {
+		t.Fatalf("Expected an *InvalidSeriesError, got %v", err)
+	}
+	if len(storage.documents()) != 0 {
+		t.Error("Expected no documents to be stored")
+	}
+}
+
+func TestStoreEach(t *testing.T) {
+	series := []*TimeSeries{
+		{Name: "a", Type: "metric", Points: pointsFrom(1, 3)},
+		{Name: "b", Type: "metric", Attributes: map[string]string{"type": "gauge"}, Points: pointsFrom(1, 3)},
+		{Name: "c", Type: "metric", Points: []Point{{Timestamp: 1}, {Timestamp: 1}}},
+	}
+
+	storage := &noPostfixStorage{}
+	c := NewWithOptions(storage, Options{Duplicates: DuplicatesError})
+	invalid, err := StoreEach(context.Background(), c, series, false, 0, "exported_")
+	if err != nil {
+		t.Fatal("Error storing series:", err)
+	}
+	if len(invalid) != 1 || invalid[0].Name != "c" {
+		t.Fatalf("Expected series c to be invalid, got %v", invalid)
+	}
+	docs := storage.documents()
+	if len(docs) != 2 || docs[1]["exported_type"] != "gauge" || docs[1]["type"] != "metric" {
+		t.Fatalf("Expected the reserved attribute to be renamed, got %v", docs)
+	}
+	if _, ok := series[1].Attributes["exported_type"]; ok {
+		t.Error("The given series was modified")
+	}
+
+	storage = &noPostfixStorage{}
+	invalid, err = StoreEach(context.Background(), New(storage), series, false, 0, "")
+	if err != nil {
+		t.Fatal("Error storing series:", err)
+	}
+	if len(invalid) != 1 || invalid[0].Name != "b" {
+		t.Fatalf("Expected series b to be invalid, got %v", invalid)
+	}
+	var reserved *ReservedAttributeError
+	if !errors.As(invalid[0], &reserved) || reserved.Attribute != "type" {
+		t.Errorf("Expected a *ReservedAttributeError, got %v", invalid[0].Err)
+	}
+	if len(storage.documents()) != 2 {
+		t.Errorf("Expected the valid series to be stored, got %v", storage.documents())
+	}
+}
+
+// A StorageClient without a postfix on dynamic fields, like Elasticsearch.
+type noPostfixStorage struct {
+	recordingStorage
+}
+
+func (s *noPostfixStorage) NeedPostfixOnDynamicField() bool {
+	return false
+}
+
+func (s *noPostfixStorage) DynamicFieldName(name string, value interface{}) string {
+	return name
+}
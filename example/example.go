@@ -35,23 +35,33 @@ func buildSeries() []*chronix.TimeSeries {
 	return series
 }
 
-func setupSolr(storageUrl *string) chronix.Client {
+func setupSolr(storageUrl *string) chronix.StorageClient {
 	u, err := url.Parse(*storageUrl)
 	if err != nil {
 		log.Fatalln("Error parsing Solr URL:", err)
 	}
-	solrStorage := chronix.NewSolrStorage(u, nil)
-	return chronix.New(solrStorage)
+	return chronix.NewSolr(u)
 }
 
-func setupElastic(storageUrl *string, withIndex *bool, deleteIndexIfExist *bool, sniffElasticNodes *bool) chronix.Client {
-	elasticStorage := chronix.NewElasticStorage(storageUrl, withIndex, deleteIndexIfExist, sniffElasticNodes)
-	return chronix.New(elasticStorage)
+func setupElastic(storageUrl *string, index *string, withIndex *bool, deleteIndexIfExist *bool, sniffElasticNodes *bool) chronix.StorageClient {
+	opts := []chronix.ElasticOption{
+		chronix.WithElasticIndex(chronix.ElasticIndex{Name: *index}),
+		chronix.WithElasticSniff(*sniffElasticNodes),
+	}
+	if *withIndex {
+		opts = append(opts, chronix.WithElasticIndexSetup(*deleteIndexIfExist))
+	}
+	elasticStorage, err := chronix.NewElastic(*storageUrl, opts...)
+	if err != nil {
+		log.Fatalln("Error creating Elastic storage:", err)
+	}
+	return elasticStorage
 }
 
 func main() {
 	storageUrl := flag.String("url", "", "The URL to the Solr endpoint to use.")
 	kind := flag.String("kind", "", "Kind: solr or elastic")
+	esIndex := flag.String("es.index", "chronix", "The name of the index, may contain a time pattern like chronix-{2006.01} (only in use with kind 'elastic')")
 	esWithIndex := flag.Bool("es.withIndex", true, "Creates an index if it do not exists")
 	esDeleteIndexIfExists := flag.Bool("es.deleteIndexIfExists", false, "Deletes the index if one exists (only in use with es.withIndex)")
 	esSniff := flag.Bool("es.sniffNodes", false, "Should the elastic client sniff for nodes (only in use with kind 'elastic')")
@@ -61,15 +71,16 @@ func main() {
 		log.Fatalln("Need to provide -url flag")
 	}
 
-	var client chronix.Client
+	var storage chronix.StorageClient
 
 	if *kind == "solr" {
-		client = setupSolr(storageUrl)
+		storage = setupSolr(storageUrl)
 	} else if *kind == "elastic" {
-		client = setupElastic(storageUrl, esWithIndex, esDeleteIndexIfExists, esSniff)
+		storage = setupElastic(storageUrl, esIndex, esWithIndex, esDeleteIndexIfExists, esSniff)
 	} else {
 		log.Fatalln("Need to provide valid -kind flag")
 	}
+	client := chronix.NewClient(storage)
 
 	log.Println("Storing time series...")
 	series := buildSeries()
@@ -80,12 +91,27 @@ func main() {
 	log.Println("Done storing.")
 
 	log.Println("Querying time series...")
-	q := "name:(testmetric) AND start:1471517965000 AND end:NOW"
-	cj := "host_s,name"
-	fl := "dataAsJson"
-	resp, err := client.Query(q, cj, fl)
+	params, err := chronix.NewQueryBuilder().
+		Name("testmetric").
+		Range(time.Unix(1471517965, 0), time.Now()).
+		Join("host", "name").
+		Fields("dataAsJson").
+		Build(storage.NeedPostfixOnDynamicField())
+	if err != nil {
+		log.Fatalln("Error building query:", err)
+	}
+	resp, err := client.Query(params.Q, params.CJ, params.FL)
 	if err != nil {
 		log.Fatalln("Error querying time series:", err)
 	}
 	log.Println("Raw query output:", string(resp))
+
+	log.Println("Querying decoded time series...")
+	result, err := client.QuerySeries(params.Q, params.CJ)
+	if err != nil {
+		log.Fatalln("Error querying time series:", err)
+	}
+	for _, ts := range result {
+		log.Printf("Series %s %v with %d points", ts.Name, ts.Attributes, len(ts.Points))
+	}
 }
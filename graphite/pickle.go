@@ -0,0 +1,446 @@
+package graphite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultMaxPickleSize is the default limit of the size of a pickle payload,
+// which is the limit of carbon as well.
+const DefaultMaxPickleSize = 1 << 20
+
+// ErrEmptyPath is returned for metrics without a path.
+var ErrEmptyPath = errors.New("metric has an empty path")
+
+// pickleList is a Python list. It is a pointer so that appends are visible
+// through the memo.
+type pickleList struct {
+	items []interface{}
+}
+
+// pickleMark marks the start of the items of a list or tuple on the stack.
+type pickleMark struct{}
+
+// DecodePickle decodes a pickle payload of the Graphite pickle protocol, which
+// is a list of (path, (timestamp, value)) tuples. Only the opcodes needed to
+// encode such lists are supported, in particular no objects are constructed.
+// Like in ParseLine, metrics with a negative timestamp get the time now.
+func DecodePickle(data []byte, now time.Time) ([]Metric, error) {
+	v, err := unpickle(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding pickle: %v", err)
+	}
+
+	items, ok := sequence(v)
+	if !ok {
+		return nil, fmt.Errorf("unexpected pickle of type %T, expected a list", v)
+	}
+	metrics := make([]Metric, 0, len(items))
+	for _, item := range items {
+		m, err := toMetric(item, now)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// toMetric converts a (path, (timestamp, value)) tuple.
+func toMetric(item interface{}, now time.Time) (Metric, error) {
+	t, ok := sequence(item)
+	if !ok || len(t) != 2 {
+		return Metric{}, fmt.Errorf("unexpected metric %v, expected (path, (timestamp, value))", item)
+	}
+	path, ok := t[0].(string)
+	if !ok {
+		return Metric{}, fmt.Errorf("unexpected path %v of type %T", t[0], t[0])
+	}
+	if path == "" {
+		return Metric{}, ErrEmptyPath
+	}
+	point, ok := sequence(t[1])
+	if !ok || len(point) != 2 {
+		return Metric{}, fmt.Errorf("unexpected datapoint %v of metric %s, expected (timestamp, value)", t[1], path)
+	}
+	ts, err := toFloat(point[0])
+	if err != nil {
+		return Metric{}, fmt.Errorf("invalid timestamp of metric %s: %v", path, err)
+	}
+	if math.IsNaN(ts) || math.IsInf(ts, 0) {
+		return Metric{}, fmt.Errorf("invalid timestamp %v of metric %s", ts, path)
+	}
+	if ts*1000 >= math.MaxInt64 {
+		return Metric{}, fmt.Errorf("timestamp %v of metric %s out of range", ts, path)
+	}
+	value, err := toFloat(point[1])
+	if err != nil {
+		return Metric{}, fmt.Errorf("invalid value of metric %s: %v", path, err)
+	}
+	m := Metric{Path: path, Value: value, Timestamp: toMillis(now)}
+	if ts >= 0 {
+		m.Timestamp = int64(ts * 1000)
+	}
+	return m, nil
+}
+
+func sequence(v interface{}) ([]interface{}, bool) {
+	switch v := v.(type) {
+	case *pickleList:
+		return v.items, true
+	case []interface{}:
+		return v, true
+	}
+	return nil, false
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("unexpected number %v of type %T", v, v)
+}
+
+// unpickler is the state of the pickle virtual machine.
+type unpickler struct {
+	data  []byte
+	pos   int
+	stack []interface{}
+	memo  map[int64]interface{}
+}
+
+// unpickle runs the pickle virtual machine and returns the resulting value.
+func unpickle(data []byte) (interface{}, error) {
+	u := &unpickler{data: data, memo: map[int64]interface{}{}}
+	for {
+		op, err := u.read(1)
+		if err != nil {
+			return nil, err
+		}
+		switch op[0] {
+		case 0x80: // PROTO
+			_, err = u.read(1)
+		case 0x95: // FRAME
+			_, err = u.read(8)
+		case '.': // STOP
+			return u.pop()
+		case '(': // MARK
+			u.push(pickleMark{})
+		case ']': // EMPTY_LIST
+			u.push(&pickleList{})
+		case ')': // EMPTY_TUPLE
+			u.push([]interface{}{})
+		case 'l': // LIST
+			var items []interface{}
+			if items, err = u.popMark(); err == nil {
+				u.push(&pickleList{items: items})
+			}
+		case 't': // TUPLE
+			var items []interface{}
+			if items, err = u.popMark(); err == nil {
+				u.push(items)
+			}
+		case 0x85, 0x86, 0x87: // TUPLE1, TUPLE2, TUPLE3
+			err = u.popTuple(int(op[0]-0x85) + 1)
+		case 'a': // APPEND
+			var v interface{}
+			if v, err = u.pop(); err == nil {
+				err = u.appendItems(v)
+			}
+		case 'e': // APPENDS
+			var items []interface{}
+			if items, err = u.popMark(); err == nil {
+				err = u.appendItems(items...)
+			}
+		case 'X': // BINUNICODE
+			err = u.pushString(4, true)
+		case 0x8c: // SHORT_BINUNICODE
+			err = u.pushString(1, true)
+		case 0x8d: // BINUNICODE8
+			err = u.pushString(8, true)
+		case 'U', 'C': // SHORT_BINSTRING, SHORT_BINBYTES
+			err = u.pushString(1, false)
+		case 'T', 'B': // BINSTRING, BINBYTES
+			err = u.pushString(4, false)
+		case 'S': // STRING
+			var line string
+			if line, err = u.readLine(); err == nil {
+				var s string
+				if s, err = unquote(line); err == nil {
+					u.push(s)
+				}
+			}
+		case 'V': // UNICODE
+			var line string
+			if line, err = u.readLine(); err == nil {
+				u.push(line)
+			}
+		case 'J': // BININT
+			var b []byte
+			if b, err = u.read(4); err == nil {
+				u.push(int64(int32(binary.LittleEndian.Uint32(b))))
+			}
+		case 'K': // BININT1
+			var b []byte
+			if b, err = u.read(1); err == nil {
+				u.push(int64(b[0]))
+			}
+		case 'M': // BININT2
+			var b []byte
+			if b, err = u.read(2); err == nil {
+				u.push(int64(binary.LittleEndian.Uint16(b)))
+			}
+		case 'I', 'L': // INT, LONG
+			var line string
+			if line, err = u.readLine(); err == nil {
+				err = u.pushInt(strings.TrimSuffix(line, "L"))
+			}
+		case 0x8a: // LONG1
+			err = u.pushLong1()
+		case 'F': // FLOAT
+			var line string
+			if line, err = u.readLine(); err == nil {
+				var f float64
+				if f, err = strconv.ParseFloat(line, 64); err == nil {
+					u.push(f)
+				}
+			}
+		case 'G': // BINFLOAT
+			var b []byte
+			if b, err = u.read(8); err == nil {
+				u.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+			}
+		case 'N': // NONE
+			u.push(nil)
+		case 0x88: // NEWTRUE
+			u.push(true)
+		case 0x89: // NEWFALSE
+			u.push(false)
+		case 'p', 'q', 'r', 0x94: // PUT, BINPUT, LONG_BINPUT, MEMOIZE
+			err = u.put(op[0])
+		case 'g', 'h', 'j': // GET, BINGET, LONG_BINGET
+			err = u.get(op[0])
+		default:
+			return nil, fmt.Errorf("unsupported opcode 0x%02x at position %d", op[0], u.pos-1)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (u *unpickler) read(n int) ([]byte, error) {
+	if n < 0 || len(u.data)-u.pos < n {
+		return nil, errors.New("unexpected end of data")
+	}
+	b := u.data[u.pos : u.pos+n]
+	u.pos += n
+	return b, nil
+}
+
+func (u *unpickler) readLine() (string, error) {
+	i := bytes.IndexByte(u.data[u.pos:], '\n')
+	if i < 0 {
+		return "", errors.New("unexpected end of data")
+	}
+	line := string(u.data[u.pos : u.pos+i])
+	u.pos += i + 1
+	return line, nil
+}
+
+func (u *unpickler) push(v interface{}) {
+	u.stack = append(u.stack, v)
+}
+
+func (u *unpickler) pop() (interface{}, error) {
+	if len(u.stack) == 0 {
+		return nil, errors.New("stack underflow")
+	}
+	v := u.stack[len(u.stack)-1]
+	u.stack = u.stack[:len(u.stack)-1]
+	if _, ok := v.(pickleMark); ok {
+		return nil, errors.New("unexpected mark")
+	}
+	return v, nil
+}
+
+// popMark pops the items up to the topmost mark.
+func (u *unpickler) popMark() ([]interface{}, error) {
+	for i := len(u.stack) - 1; i >= 0; i-- {
+		if _, ok := u.stack[i].(pickleMark); ok {
+			items := append([]interface{}{}, u.stack[i+1:]...)
+			u.stack = u.stack[:i]
+			return items, nil
+		}
+	}
+	return nil, errors.New("missing mark")
+}
+
+func (u *unpickler) popTuple(n int) error {
+	if len(u.stack) < n {
+		return errors.New("stack underflow")
+	}
+	items := append([]interface{}{}, u.stack[len(u.stack)-n:]...)
+	for _, v := range items {
+		if _, ok := v.(pickleMark); ok {
+			return errors.New("unexpected mark")
+		}
+	}
+	u.stack = u.stack[:len(u.stack)-n]
+	u.push(items)
+	return nil
+}
+
+func (u *unpickler) appendItems(items ...interface{}) error {
+	if len(u.stack) == 0 {
+		return errors.New("stack underflow")
+	}
+	l, ok := u.stack[len(u.stack)-1].(*pickleList)
+	if !ok {
+		return errors.New("append to a value that is not a list")
+	}
+	l.items = append(l.items, items...)
+	return nil
+}
+
+// pushString pushes a string with a little-endian length of size bytes.
+func (u *unpickler) pushString(size int, unicode bool) error {
+	b, err := u.read(size)
+	if err != nil {
+		return err
+	}
+	var n uint64
+	for i := size - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	if n > uint64(len(u.data)) {
+		return errors.New("unexpected end of data")
+	}
+	s, err := u.read(int(n))
+	if err != nil {
+		return err
+	}
+	if unicode && !utf8.Valid(s) {
+		return errors.New("invalid UTF-8 string")
+	}
+	u.push(string(s))
+	return nil
+}
+
+func (u *unpickler) pushInt(s string) error {
+	// Protocol 0 encodes booleans as "01" and "00".
+	switch s {
+	case "01":
+		u.push(true)
+		return nil
+	case "00":
+		u.push(false)
+		return nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer '%s'", s)
+	}
+	u.push(i)
+	return nil
+}
+
+// pushLong1 pushes a little-endian two's complement integer of at most 8 bytes.
+func (u *unpickler) pushLong1() error {
+	b, err := u.read(1)
+	if err != nil {
+		return err
+	}
+	n := int(b[0])
+	if n > 8 {
+		return fmt.Errorf("integer of %d bytes is too large", n)
+	}
+	if b, err = u.read(n); err != nil {
+		return err
+	}
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	if n > 0 && n < 8 && b[n-1]&0x80 != 0 {
+		// Sign-extend negative numbers.
+		v |= ^uint64(0) << uint(8*n)
+	}
+	u.push(int64(v))
+	return nil
+}
+
+func (u *unpickler) memoIndex(op byte) (int64, error) {
+	switch op {
+	case 'p', 'g':
+		line, err := u.readLine()
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(line, 10, 64)
+	case 'q', 'h':
+		b, err := u.read(1)
+		if err != nil {
+			return 0, err
+		}
+		return int64(b[0]), nil
+	default:
+		b, err := u.read(4)
+		if err != nil {
+			return 0, err
+		}
+		return int64(binary.LittleEndian.Uint32(b)), nil
+	}
+}
+
+func (u *unpickler) put(op byte) error {
+	if len(u.stack) == 0 {
+		return errors.New("stack underflow")
+	}
+	i := int64(len(u.memo))
+	if op != 0x94 {
+		var err error
+		if i, err = u.memoIndex(op); err != nil {
+			return err
+		}
+	}
+	u.memo[i] = u.stack[len(u.stack)-1]
+	return nil
+}
+
+func (u *unpickler) get(op byte) error {
+	i, err := u.memoIndex(op)
+	if err != nil {
+		return err
+	}
+	v, ok := u.memo[i]
+	if !ok {
+		return fmt.Errorf("missing memo entry %d", i)
+	}
+	u.push(v)
+	return nil
+}
+
+// unquote unquotes a Python string literal.
+func unquote(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		s = `"` + strings.Replace(strings.Replace(s[1:len(s)-1], `\'`, `'`, -1), `"`, `\"`, -1) + `"`
+	}
+	v, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid string literal %s", s)
+	}
+	return v, nil
+}
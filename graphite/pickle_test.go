@@ -0,0 +1,77 @@
+package graphite
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodePickle(t *testing.T) {
+	want := []Metric{
+		{Path: "a.b", Value: 1.5, Timestamp: 1500000000000},
+		{Path: "c.d", Value: -2, Timestamp: 1500000001500},
+	}
+
+	// pickle.dumps([("a.b", (1500000000, 1.5)), ("c.d", (1500000001.5, -2))], protocol)
+	tests := map[string]string{
+		"protocol 0": "(lp0\n(S'a.b'\np1\n(I1500000000\nF1.5\ntp2\ntp3\na(Vc.d\np4\n(F1500000001.5\nI-2\ntp5\ntp6\na.",
+		"protocol 2": "\x80\x02]q\x00(X\x03\x00\x00\x00a.bq\x01J\x00/hYG?\xf8\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03X\x03\x00\x00\x00c.dq\x04GA\xd6Z\x0b\xc0`\x00\x00J\xfe\xff\xff\xff\x86q\x05\x86q\x06e.",
+		"protocol 4": "\x80\x04\x955\x00\x00\x00\x00\x00\x00\x00]\x94(\x8c\x03a.b\x94J\x00/hYG?\xf8\x00\x00\x00\x00\x00\x00\x86\x94\x86\x94\x8c\x03c.d\x94GA\xd6Z\x0b\xc0`\x00\x00J\xfe\xff\xff\xff\x86\x94\x86\x94e.",
+	}
+	for name, data := range tests {
+		got, err := DecodePickle([]byte(data), time.Now())
+		if err != nil {
+			t.Fatalf("%s: error decoding pickle: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: unexpected metrics. Want %+v, got %+v", name, want, got)
+		}
+	}
+
+	// Memoized paths and tuples are shared: pickle.dumps([("x", (2**40, 3))] * 2, 2)
+	got, err := DecodePickle([]byte("\x80\x02]q\x00(X\x01\x00\x00\x00xq\x01\x8a\x06\x00\x00\x00\x00\x00\x01K\x03\x86q\x02\x86q\x03h\x03e."), time.Now())
+	if err != nil {
+		t.Fatal("Error decoding pickle:", err)
+	}
+	m := Metric{Path: "x", Value: 3, Timestamp: 1 << 40 * 1000}
+	if want := []Metric{m, m}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected metrics. Want %+v, got %+v", want, got)
+	}
+}
+
+func TestDecodePickleNegativeTimestamp(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	// pickle.dumps([("x", (-2, 1))], 2)
+	got, err := DecodePickle([]byte("\x80\x02]q\x00(X\x01\x00\x00\x00xJ\xfe\xff\xff\xffK\x01\x86\x86e."), now)
+	if err != nil {
+		t.Fatal("Error decoding pickle:", err)
+	}
+	if want := []Metric{{Path: "x", Value: 1, Timestamp: 1500000000000}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected metrics. Want %+v, got %+v", want, got)
+	}
+}
+
+func TestDecodePickleErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"(lp0\n",
+		"\x80\x02]X\xff\xff\xff\x7fa.",
+		// pickle.dumps(os.system, 2) must not construct objects.
+		"\x80\x02cposix\nsystem\nq\x00.",
+		"cos\nsystem\n(S'true'\ntR.",
+		"\x80\x02K\x01.",
+		"\x80\x02]q\x00X\x01\x00\x00\x00xq\x01a.",
+		"\x80\x02]q\x00(X\x00\x00\x00\x00K\x01K\x02\x86\x86e.",
+		"\x80\x02]q\x00(X\x01\x00\x00\x00xK\x01X\x01\x00\x00\x00y\x86\x86e.",
+		// pickle.dumps([("x", (float("nan"), 1))], 2) and the same with inf.
+		"\x80\x02]q\x00(X\x01\x00\x00\x00xG\x7f\xf8\x00\x00\x00\x00\x00\x00K\x01\x86\x86e.",
+		"\x80\x02]q\x00(X\x01\x00\x00\x00xG\x7f\xf0\x00\x00\x00\x00\x00\x00K\x01\x86\x86e.",
+		// pickle.dumps([("x", (1e17, 1))], 2) overflows in milliseconds.
+		"\x80\x02]q\x00(X\x01\x00\x00\x00xG\x43\x76\x34\x57\x85\xd8\xa0\x00K\x01\x86\x86e.",
+	}
+	for i, data := range tests {
+		if _, err := DecodePickle([]byte(data), time.Now()); err == nil {
+			t.Errorf("%d. Expected an error", i)
+		}
+	}
+}
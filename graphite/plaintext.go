@@ -0,0 +1,53 @@
+// Package graphite implements a listener for the Graphite plaintext and pickle
+// protocols that stores the received metrics in Chronix.
+package graphite
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Metric is a value of a Graphite metric path at a point in time.
+type Metric struct {
+	Path  string
+	Value float64
+	// Timestamp is the time of the value in milliseconds since the epoch.
+	Timestamp int64
+}
+
+// ParseLine parses a line of the plaintext protocol of the form "path value [timestamp]".
+// The timestamp is given in seconds since the epoch and may have a fraction. Metrics
+// without a timestamp or with a negative one get the time now.
+func ParseLine(line string, now time.Time) (Metric, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 && len(fields) != 3 {
+		return Metric{}, fmt.Errorf("invalid line '%s': expected 'path value timestamp'", line)
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return Metric{}, fmt.Errorf("invalid value '%s' of metric %s", fields[1], fields[0])
+	}
+
+	m := Metric{Path: fields[0], Value: value, Timestamp: toMillis(now)}
+	if len(fields) == 3 {
+		ts, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || math.IsNaN(ts) || math.IsInf(ts, 0) {
+			return Metric{}, fmt.Errorf("invalid timestamp '%s' of metric %s", fields[2], fields[0])
+		}
+		if ts*1000 >= math.MaxInt64 {
+			return Metric{}, fmt.Errorf("timestamp '%s' of metric %s out of range", fields[2], fields[0])
+		}
+		if ts >= 0 {
+			m.Timestamp = int64(ts * 1000)
+		}
+	}
+	return m, nil
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,35 @@
+package graphite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	tests := []struct {
+		line string
+		want Metric
+	}{
+		{line: "servers.web-01.cpu.load 0.5 1470784794", want: Metric{Path: "servers.web-01.cpu.load", Value: 0.5, Timestamp: 1470784794000}},
+		{line: "  a.b\t-3   1470784794.25\r", want: Metric{Path: "a.b", Value: -3, Timestamp: 1470784794250}},
+		{line: "a.b 1", want: Metric{Path: "a.b", Value: 1, Timestamp: 1500000000000}},
+		{line: "a.b 1 -1", want: Metric{Path: "a.b", Value: 1, Timestamp: 1500000000000}},
+	}
+	for i, test := range tests {
+		got, err := ParseLine(test.line, now)
+		if err != nil {
+			t.Fatalf("%d. Error parsing line: %v", i, err)
+		}
+		if got != test.want {
+			t.Errorf("%d. Unexpected metric. Want %+v, got %+v", i, test.want, got)
+		}
+	}
+
+	for _, line := range []string{"a.b", "a.b 1 2 3", "a.b x 1", "a.b 1 x", "a.b 1 NaN", "a.b 1 1e17"} {
+		if _, err := ParseLine(line, now); err == nil {
+			t.Errorf("Expected an error for line %q", line)
+		}
+	}
+}
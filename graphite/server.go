@@ -0,0 +1,355 @@
+package graphite
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+)
+
+// DefaultType is the default type of the stored series.
+const DefaultType = "metric"
+
+// DefaultMaxBufferedPoints is the default limit of the points buffered by a Server
+// (about 16 MB), after which it stops reading from the connections.
+const DefaultMaxBufferedPoints = 1 << 20
+
+// maxDatagramSize is the maximum size of a UDP datagram.
+const maxDatagramSize = 64 << 10
+
+// ErrServerClosed is returned by the Serve methods after the server has been shut down.
+var ErrServerClosed = errors.New("graphite server closed")
+
+// Options configures a Server.
+type Options struct {
+	// Templates map metric paths to series names and attributes. The first
+	// matching template is used. Paths without a matching template are used
+	// as series names.
+	Templates []Template
+	// Type is the type of the stored series. If it is empty, DefaultType is used.
+	Type string
+	// MaxPickleSize is the maximum size of a pickle payload in bytes. If it is 0,
+	// DefaultMaxPickleSize is used.
+	MaxPickleSize int
+	// ReadTimeout closes connections that did not send data within the timeout.
+	// If it is 0, connections are kept open until the client closes them.
+	ReadTimeout time.Duration
+	// Buffer configures the buffered writer the metrics are written to. Its
+	// MaxBufferedPoints stops reading from the connections while Chronix cannot
+	// keep up; if it is 0, DefaultMaxBufferedPoints is used. Set its OnError to be
+	// notified of errors of background flushes.
+	Buffer chronix.BufferedWriterOptions
+	// OnError is called with errors of invalid metrics and connections. If it is
+	// nil, the errors are ignored. It must not block.
+	OnError func(error)
+}
+
+// A Server receives metrics over the Graphite plaintext and pickle protocols
+// and stores them through a BufferedWriter.
+type Server struct {
+	opts   Options
+	writer *chronix.BufferedWriter
+
+	// ctx is canceled when a shutdown times out to abort blocked writes.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mtx       sync.Mutex
+	listeners map[io.Closer]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+
+	wg sync.WaitGroup
+}
+
+// NewServer creates a new Server that stores the metrics through the client.
+// It returns an error if a template is invalid.
+func NewServer(c chronix.Client, opts Options) (*Server, error) {
+	for _, t := range opts.Templates {
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Type == "" {
+		opts.Type = DefaultType
+	}
+	if opts.MaxPickleSize <= 0 {
+		opts.MaxPickleSize = DefaultMaxPickleSize
+	}
+	if opts.Buffer.MaxBufferedPoints <= 0 {
+		opts.Buffer.MaxBufferedPoints = DefaultMaxBufferedPoints
+	}
+	if opts.OnError == nil {
+		opts.OnError = func(error) {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		opts:      opts,
+		writer:    chronix.NewBufferedWriter(c, opts.Buffer),
+		ctx:       ctx,
+		cancel:    cancel,
+		listeners: map[io.Closer]struct{}{},
+		conns:     map[net.Conn]struct{}{},
+	}, nil
+}
+
+// ServeTCP accepts connections of the plaintext protocol on the listener.
+// It always returns a non-nil error, ErrServerClosed after Shutdown.
+func (s *Server) ServeTCP(l net.Listener) error {
+	return s.serve(l, s.handlePlaintext)
+}
+
+// ServePickle accepts connections of the pickle protocol on the listener.
+// It always returns a non-nil error, ErrServerClosed after Shutdown.
+func (s *Server) ServePickle(l net.Listener) error {
+	return s.serve(l, s.handlePickle)
+}
+
+// ServeUDP reads datagrams of the plaintext protocol from the connection.
+// It always returns a non-nil error, ErrServerClosed after Shutdown.
+func (s *Server) ServeUDP(pc net.PacketConn) error {
+	if !s.trackListener(pc, true) {
+		pc.Close()
+		return ErrServerClosed
+	}
+	defer s.wg.Done()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			if err := s.writeLine(string(line)); err != nil {
+				if s.isClosed() {
+					return ErrServerClosed
+				}
+				return err
+			}
+		}
+	}
+}
+
+// Shutdown stops the server gracefully: it closes all listeners, stops reading
+// from the open connections, waits until the received metrics have been written
+// to the buffer and stores them. If ctx is done before, blocked writes are
+// aborted and the metrics still being received are dropped.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return nil
+	}
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		// Let pending reads return, the handlers close the connections.
+		c.SetReadDeadline(time.Now())
+	}
+	s.mtx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.cancel()
+		<-done
+	}
+	s.cancel()
+
+	if cerr := s.writer.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *Server) serve(l net.Listener, handle func(net.Conn) error) error {
+	if !s.trackListener(l, false) {
+		l.Close()
+		return ErrServerClosed
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if !s.trackConn(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrackConn(conn)
+			if err := handle(conn); err != nil && !s.isClosed() {
+				s.opts.OnError(fmt.Errorf("error reading from %s: %v", conn.RemoteAddr(), err))
+			}
+		}()
+	}
+}
+
+// handlePlaintext reads lines of the plaintext protocol from the connection.
+func (s *Server) handlePlaintext(conn net.Conn) error {
+	scanner := bufio.NewScanner(s.reader(conn))
+	for scanner.Scan() {
+		if err := s.writeLine(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// handlePickle reads length-prefixed pickle payloads from the connection.
+func (s *Server) handlePickle(conn net.Conn) error {
+	r := s.reader(conn)
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := binary.BigEndian.Uint32(header[:])
+		if size > uint32(s.opts.MaxPickleSize) {
+			return fmt.Errorf("pickle of %d bytes exceeds the maximum size of %d bytes", size, s.opts.MaxPickleSize)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+
+		metrics, err := DecodePickle(payload, time.Now())
+		if err != nil {
+			// The payload is framed, so the connection can still be read.
+			s.opts.OnError(fmt.Errorf("error reading from %s: %v", conn.RemoteAddr(), err))
+			continue
+		}
+		for _, m := range metrics {
+			if err := s.write(m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeLine parses and writes a line of the plaintext protocol. Invalid lines
+// are reported and skipped.
+func (s *Server) writeLine(line string) error {
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	m, err := ParseLine(line, time.Now())
+	if err != nil {
+		s.opts.OnError(err)
+		return nil
+	}
+	return s.write(m)
+}
+
+// write maps the metric to a series and writes it to the buffer. It blocks
+// while the buffer is full.
+func (s *Server) write(m Metric) error {
+	name, attributes := mapPath(s.opts.Templates, m.Path)
+	return s.writer.WriteContext(s.ctx, &chronix.TimeSeries{
+		Name:       name,
+		Type:       s.opts.Type,
+		Attributes: attributes,
+		Points:     []chronix.Point{{Timestamp: m.Timestamp, Value: m.Value}},
+	})
+}
+
+// reader returns a reader of the connection that applies the read timeout.
+func (s *Server) reader(conn net.Conn) io.Reader {
+	if s.opts.ReadTimeout <= 0 {
+		return conn
+	}
+	return &timeoutReader{conn: conn, timeout: s.opts.ReadTimeout, server: s}
+}
+
+// trackListener registers a listener to be closed on shutdown. If it writes
+// metrics itself, it is added to the wait group and the caller has to release it.
+func (s *Server) trackListener(l io.Closer, writes bool) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	if writes {
+		s.wg.Add(1)
+	}
+	return true
+}
+
+func (s *Server) trackConn(c net.Conn) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(c net.Conn) {
+	c.Close()
+	s.mtx.Lock()
+	delete(s.conns, c)
+	s.mtx.Unlock()
+	s.wg.Done()
+}
+
+func (s *Server) isClosed() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.closed
+}
+
+// timeoutReader extends the read deadline of a connection before every read
+// unless the server is shutting down.
+type timeoutReader struct {
+	conn    net.Conn
+	timeout time.Duration
+	server  *Server
+}
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	// Holding the lock keeps the deadline set by Shutdown from being overwritten.
+	r.server.mtx.Lock()
+	if !r.server.closed {
+		r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	}
+	r.server.mtx.Unlock()
+	return r.conn.Read(p)
+}
@@ -0,0 +1,154 @@
+package graphite
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+)
+
+// recordingClient is a chronix.Client that records the stored points per series name.
+type recordingClient struct {
+	chronix.Client
+
+	mtx    sync.Mutex
+	points map[string][]chronix.Point
+}
+
+func (c *recordingClient) StoreContext(ctx context.Context, series []*chronix.TimeSeries, commit bool, commitWithin time.Duration) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, ts := range series {
+		key := ts.Name
+		if host, ok := ts.Attributes["host"]; ok {
+			key += "@" + host
+		}
+		c.points[key] = append(c.points[key], ts.Points...)
+	}
+	return nil
+}
+
+type byTimestamp []chronix.Point
+
+func (p byTimestamp) Len() int           { return len(p) }
+func (p byTimestamp) Less(i, j int) bool { return p[i].Timestamp < p[j].Timestamp }
+func (p byTimestamp) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func TestServer(t *testing.T) {
+	client := &recordingClient{points: map[string][]chronix.Point{}}
+	server, err := NewServer(client, Options{
+		Templates: []Template{{Filter: "servers", Pattern: ".host.name*"}},
+		OnError:   func(err error) {},
+	})
+	if err != nil {
+		t.Fatal("Error creating server:", err)
+	}
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pickle, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 3)
+	go func() { errs <- server.ServeTCP(tcp) }()
+	go func() { errs <- server.ServePickle(pickle) }()
+	go func() { errs <- server.ServeUDP(udp) }()
+
+	conn, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("servers.web-01.cpu.load 0.5 1470784794\ninvalid\nservers.web-01.cpu.load 0.7 1470784795\n"))
+	conn.Close()
+
+	// pickle.dumps([("a.b", (1500000000, 1.5)), ("c.d", (1500000001.5, -2))], 2)
+	payload := []byte("\x80\x02]q\x00(X\x03\x00\x00\x00a.bq\x01J\x00/hYG?\xf8\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03X\x03\x00\x00\x00c.dq\x04GA\xd6Z\x0b\xc0`\x00\x00J\xfe\xff\xff\xff\x86q\x05\x86q\x06e.")
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(payload)))
+	conn, err = net.Dial("tcp", pickle.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write(append(header, payload...))
+
+	udpConn, err := net.Dial("udp", udp.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpConn.Write([]byte("servers.web-02.cpu.load 0.9 1470784794"))
+	udpConn.Close()
+
+	want := map[string][]chronix.Point{
+		"cpu.load@web-01": {{Timestamp: 1470784794000, Value: 0.5}, {Timestamp: 1470784795000, Value: 0.7}},
+		"cpu.load@web-02": {{Timestamp: 1470784794000, Value: 0.9}},
+		"a.b":             {{Timestamp: 1500000000000, Value: 1.5}},
+		"c.d":             {{Timestamp: 1500000001500, Value: -2}},
+	}
+
+	// Wait until all metrics have been read, the pickle connection stays open.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := server.writer.Flush(); err != nil {
+			t.Fatal("Error flushing:", err)
+		}
+		client.mtx.Lock()
+		n := len(client.points)
+		client.mtx.Unlock()
+		if n == len(want) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal("Error shutting down:", err)
+	}
+	conn.Close()
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != ErrServerClosed {
+			t.Errorf("Expected ErrServerClosed, got %v", err)
+		}
+	}
+	if err := server.ServeTCP(tcp); err != ErrServerClosed {
+		t.Errorf("Expected ErrServerClosed after shutdown, got %v", err)
+	}
+
+	for _, points := range client.points {
+		sort.Stable(byTimestamp(points))
+	}
+	if !reflect.DeepEqual(client.points, want) {
+		t.Fatalf("Unexpected points. Want %v, got %v", want, client.points)
+	}
+}
+
+func TestNewServerDefaults(t *testing.T) {
+	s, err := NewServer(&recordingClient{}, Options{})
+	if err != nil {
+		t.Fatal("Error creating server:", err)
+	}
+	defer s.Shutdown(context.Background())
+	if s.opts.Buffer.MaxBufferedPoints != DefaultMaxBufferedPoints {
+		t.Errorf("Expected the buffer to be limited to %d points, got %d", DefaultMaxBufferedPoints, s.opts.Buffer.MaxBufferedPoints)
+	}
+}
+
+func TestNewServerInvalidTemplate(t *testing.T) {
+	if _, err := NewServer(&recordingClient{}, Options{Templates: []Template{{Pattern: "host"}}}); err == nil {
+		t.Fatal("Expected an error for an invalid template")
+	}
+}
@@ -0,0 +1,110 @@
+package graphite
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A Template maps the dotted metric paths matching its filter to the name and
+// attributes of a series.
+//
+// The pattern assigns the elements of a path by position: "name" makes the
+// element part of the series name, "name*" makes the element and all following
+// elements part of the name, an empty element skips the element and any other
+// value stores the element in the attribute of that name. Elements of the name
+// are joined by dots. For example, the pattern "..host.name*" maps the path
+// "servers.eu.web-01.cpu.load" to the name "cpu.load" and the attribute host=web-01.
+type Template struct {
+	// Filter selects the paths the template applies to. Its dotted elements are
+	// matched against the first elements of a path with path.Match, e.g.
+	// "servers.*". An empty filter matches all paths.
+	Filter string
+	// Pattern maps the elements of a path.
+	Pattern string
+	// Attributes are added to the attributes of the series.
+	Attributes map[string]string
+}
+
+// validate checks the filter and the pattern of the template.
+func (t Template) validate() error {
+	if t.Filter != "" {
+		for _, f := range strings.Split(t.Filter, ".") {
+			if _, err := path.Match(f, ""); err != nil {
+				return fmt.Errorf("invalid filter '%s' of template: %v", t.Filter, err)
+			}
+		}
+	}
+	hasName := false
+	for i, p := range strings.Split(t.Pattern, ".") {
+		switch p {
+		case "name":
+			hasName = true
+		case "name*":
+			hasName = true
+			if i != strings.Count(t.Pattern, ".") {
+				return fmt.Errorf("invalid pattern '%s' of template: name* has to be the last element", t.Pattern)
+			}
+		}
+	}
+	if !hasName {
+		return fmt.Errorf("invalid pattern '%s' of template: no name element", t.Pattern)
+	}
+	return nil
+}
+
+// matches reports whether the filter of the template matches the path elements.
+func (t Template) matches(elements []string) bool {
+	if t.Filter == "" {
+		return true
+	}
+	filter := strings.Split(t.Filter, ".")
+	if len(filter) > len(elements) {
+		return false
+	}
+	for i, f := range filter {
+		if ok, _ := path.Match(f, elements[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// apply maps the path elements to the name and attributes of a series.
+func (t Template) apply(elements []string) (string, map[string]string) {
+	var name []string
+	attributes := make(map[string]string, len(t.Attributes))
+	for k, v := range t.Attributes {
+		attributes[k] = v
+	}
+	for i, p := range strings.Split(t.Pattern, ".") {
+		if i >= len(elements) {
+			break
+		}
+		switch p {
+		case "":
+		case "name":
+			name = append(name, elements[i])
+		case "name*":
+			name = append(name, elements[i:]...)
+		default:
+			attributes[p] = elements[i]
+		}
+	}
+	return strings.Join(name, "."), attributes
+}
+
+// mapPath maps a metric path to the name and attributes of a series using the
+// first matching template. Paths without a matching template are used as names.
+func mapPath(templates []Template, metricPath string) (string, map[string]string) {
+	elements := strings.Split(metricPath, ".")
+	for _, t := range templates {
+		if !t.matches(elements) {
+			continue
+		}
+		if name, attributes := t.apply(elements); name != "" {
+			return name, attributes
+		}
+	}
+	return metricPath, map[string]string{}
+}
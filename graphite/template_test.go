@@ -0,0 +1,63 @@
+package graphite
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMapPath(t *testing.T) {
+	templates := []Template{
+		{Filter: "servers.*", Pattern: ".region.host.name*", Attributes: map[string]string{"source": "servers"}},
+		{Filter: "stats", Pattern: ".name.name.agg"},
+		{Pattern: "name"},
+	}
+
+	tests := []struct {
+		path       string
+		name       string
+		attributes map[string]string
+	}{
+		{
+			path:       "servers.eu.web-01.cpu.load",
+			name:       "cpu.load",
+			attributes: map[string]string{"source": "servers", "region": "eu", "host": "web-01"},
+		},
+		{
+			path:       "stats.api.requests.p99",
+			name:       "api.requests",
+			attributes: map[string]string{"agg": "p99"},
+		},
+		{
+			path:       "stats.api",
+			name:       "api",
+			attributes: map[string]string{},
+		},
+		{
+			path:       "jobs.runs",
+			name:       "jobs",
+			attributes: map[string]string{},
+		},
+	}
+	for _, test := range tests {
+		name, attributes := mapPath(templates, test.path)
+		if name != test.name || !reflect.DeepEqual(attributes, test.attributes) {
+			t.Errorf("Unexpected mapping of %s. Want %s %v, got %s %v", test.path, test.name, test.attributes, name, attributes)
+		}
+	}
+
+	if name, attributes := mapPath(nil, "a.b.c"); name != "a.b.c" || len(attributes) != 0 {
+		t.Errorf("Expected the path as name without templates, got %s %v", name, attributes)
+	}
+}
+
+func TestTemplateValidate(t *testing.T) {
+	for _, tmpl := range []Template{
+		{Pattern: "host.region"},
+		{Pattern: "name*.host"},
+		{Filter: "servers.[", Pattern: "name"},
+	} {
+		if err := tmpl.validate(); err == nil {
+			t.Errorf("Expected an error for template %+v", tmpl)
+		}
+	}
+}
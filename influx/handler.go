@@ -0,0 +1,217 @@
+package influx
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+)
+
+// DefaultMaxRequestSize is the default limit of the uncompressed size of a request.
+const DefaultMaxRequestSize = 32 << 20
+
+// DefaultType is the default type of the stored series.
+const DefaultType = "metric"
+
+// ReservedTagPrefix is prepended to attributes named like a field of the chunk
+// documents, e.g. a "type" tag is stored as "exported_type".
+const ReservedTagPrefix = "exported_"
+
+// A Naming maps a field of a point to the name and attributes of a series.
+type Naming func(measurement, field string, tags map[string]string) (name string, attributes map[string]string)
+
+// MeasurementField names series "measurement.field", e.g. "cpu.usage_idle", and
+// uses the tags as attributes.
+func MeasurementField(measurement, field string, tags map[string]string) (string, map[string]string) {
+	return measurement + "." + field, copyTags(tags, 0)
+}
+
+// FieldAttribute names series after the measurement and stores the field name in
+// the given attribute, in addition to the tags.
+func FieldAttribute(attribute string) Naming {
+	return func(measurement, field string, tags map[string]string) (string, map[string]string) {
+		attributes := copyTags(tags, 1)
+		attributes[attribute] = field
+		return measurement, attributes
+	}
+}
+
+func copyTags(tags map[string]string, extra int) map[string]string {
+	attributes := make(map[string]string, len(tags)+extra)
+	for k, v := range tags {
+		attributes[k] = v
+	}
+	return attributes
+}
+
+// Options configures a Handler.
+type Options struct {
+	// Naming maps fields to series. If it is nil, MeasurementField is used.
+	Naming Naming
+	// Type is the type of the stored series. If it is empty, DefaultType is used.
+	Type string
+	// MaxRequestSize is the maximum uncompressed size of a request in bytes.
+	// If it is 0, DefaultMaxRequestSize is used.
+	MaxRequestSize int64
+	// CommitWithin is passed to the client when storing series.
+	CommitWithin time.Duration
+}
+
+// ToTimeSeries converts points into time series, one per distinct name and
+// attributes. Booleans are stored as 1 and 0, string fields are skipped as
+// Chronix only stores numeric values. Timestamps are truncated to milliseconds.
+func ToTimeSeries(points []Point, naming Naming, typ string) []*chronix.TimeSeries {
+	var series []*chronix.TimeSeries
+	byKey := map[string]*chronix.TimeSeries{}
+	for _, p := range points {
+		for _, f := range p.Fields {
+			value, ok := numericValue(f.Value)
+			if !ok {
+				continue
+			}
+			name, attributes := naming(p.Measurement, f.Key, p.Tags)
+			key := seriesKey(name, attributes)
+			ts, ok := byKey[key]
+			if !ok {
+				ts = &chronix.TimeSeries{
+					Name:       name,
+					Type:       typ,
+					Attributes: attributes,
+				}
+				byKey[key] = ts
+				series = append(series, ts)
+			}
+			ts.Points = append(ts.Points, chronix.Point{
+				Timestamp: p.Timestamp / int64(time.Millisecond),
+				Value:     value,
+			})
+		}
+	}
+	return series
+}
+
+// numericValue converts a field value into a float64.
+func numericValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// seriesKey returns a string identifying a series by its name and attributes.
+func seriesKey(name string, attributes map[string]string) string {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{name}
+	for _, k := range keys {
+		parts = append(parts, k, attributes[k])
+	}
+	return strings.Join(parts, "\xff")
+}
+
+// A Handler is an http.Handler compatible with the /write endpoint of InfluxDB.
+// It parses the line protocol and stores every numeric field as a Chronix series.
+// The precision parameter is supported, other parameters such as db are ignored.
+// Like InfluxDB, series the storage rejects are reported as a partial write with
+// a client error, while the other series are stored.
+type Handler struct {
+	client chronix.Client
+	opts   Options
+}
+
+// NewHandler creates a new Handler that stores the points through the client.
+func NewHandler(c chronix.Client, opts Options) *Handler {
+	if opts.Naming == nil {
+		opts.Naming = MeasurementField
+	}
+	if opts.Type == "" {
+		opts.Type = DefaultType
+	}
+	if opts.MaxRequestSize <= 0 {
+		opts.MaxRequestSize = DefaultMaxRequestSize
+	}
+	return &Handler{
+		client: c,
+		opts:   opts,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	precision, err := ParsePrecision(r.URL.Query().Get("precision"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeError(w, fmt.Sprintf("error decompressing request: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	lr := &io.LimitedReader{R: body, N: h.opts.MaxRequestSize + 1}
+
+	points, err := Parse(lr, precision, time.Now())
+	if lr.N <= 0 {
+		writeError(w, "request is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series := ToTimeSeries(points, h.opts.Naming, h.opts.Type)
+	invalid, err := chronix.StoreEach(r.Context(), h.client, series, false, h.opts.CommitWithin, ReservedTagPrefix)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(invalid) > 0 {
+		msgs := make([]string, len(invalid))
+		for i, e := range invalid {
+			msgs[i] = e.Error()
+		}
+		writeError(w, "partial write: "+strings.Join(msgs, "; "), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeError writes an error response like InfluxDB does.
+func writeError(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Influxdb-Error", msg)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
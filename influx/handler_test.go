@@ -0,0 +1,189 @@
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+)
+
+// recordingClient is a chronix.Client that records the stored series.
+type recordingClient struct {
+	chronix.Client
+
+	// reject is the name of series rejected with an *InvalidSeriesError.
+	reject string
+
+	mtx    sync.Mutex
+	series []*chronix.TimeSeries
+}
+
+func (c *recordingClient) StoreContext(ctx context.Context, series []*chronix.TimeSeries, commit bool, commitWithin time.Duration) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, ts := range series {
+		if ts.Name == c.reject {
+			return &chronix.InvalidSeriesError{Name: ts.Name, Attributes: ts.Attributes, Err: errors.New("rejected")}
+		}
+	}
+	c.series = append(c.series, series...)
+	return nil
+}
+
+func TestToTimeSeries(t *testing.T) {
+	points := []Point{
+		{Measurement: "cpu", Tags: map[string]string{"host": "a"}, Fields: []Field{{"idle", 98.0}, {"user", int64(2)}}, Timestamp: 1500000000},
+		{Measurement: "cpu", Tags: map[string]string{"host": "a"}, Fields: []Field{{"idle", 97.0}, {"msg", "text"}}, Timestamp: 2500000000},
+		{Measurement: "up", Tags: map[string]string{}, Fields: []Field{{"ok", true}}, Timestamp: 1000000000},
+	}
+
+	tests := []struct {
+		naming Naming
+		want   []*chronix.TimeSeries
+	}{
+		{
+			naming: MeasurementField,
+			want: []*chronix.TimeSeries{
+				{Name: "cpu.idle", Type: "metric", Attributes: map[string]string{"host": "a"}, Points: []chronix.Point{{Timestamp: 1500, Value: 98}, {Timestamp: 2500, Value: 97}}},
+				{Name: "cpu.user", Type: "metric", Attributes: map[string]string{"host": "a"}, Points: []chronix.Point{{Timestamp: 1500, Value: 2}}},
+				{Name: "up.ok", Type: "metric", Attributes: map[string]string{}, Points: []chronix.Point{{Timestamp: 1000, Value: 1}}},
+			},
+		},
+		{
+			naming: FieldAttribute("field"),
+			want: []*chronix.TimeSeries{
+				{Name: "cpu", Type: "metric", Attributes: map[string]string{"host": "a", "field": "idle"}, Points: []chronix.Point{{Timestamp: 1500, Value: 98}, {Timestamp: 2500, Value: 97}}},
+				{Name: "cpu", Type: "metric", Attributes: map[string]string{"host": "a", "field": "user"}, Points: []chronix.Point{{Timestamp: 1500, Value: 2}}},
+				{Name: "up", Type: "metric", Attributes: map[string]string{"field": "ok"}, Points: []chronix.Point{{Timestamp: 1000, Value: 1}}},
+			},
+		},
+	}
+
+	for i, test := range tests {
+		if got := ToTimeSeries(points, test.naming, "metric"); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d. Unexpected series. Want %v, got %v", i, test.want, got)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	client := &recordingClient{}
+	server := httptest.NewServer(NewHandler(client, Options{}))
+	defer server.Close()
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write([]byte("cpu,host=a idle=98 1470784794000\ncpu,host=a idle=97 1470784795000\n"))
+	gz.Close()
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/write?db=test&precision=ms", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Error sending request:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Unexpected status code %d", resp.StatusCode)
+	}
+
+	want := []*chronix.TimeSeries{{
+		Name:       "cpu.idle",
+		Type:       "metric",
+		Attributes: map[string]string{"host": "a"},
+		Points:     []chronix.Point{{Timestamp: 1470784794000, Value: 98}, {Timestamp: 1470784795000, Value: 97}},
+	}}
+	if !reflect.DeepEqual(client.series, want) {
+		t.Fatalf("Unexpected series. Want %v, got %v", want, client.series)
+	}
+}
+
+func TestHandlerPartialWrite(t *testing.T) {
+	client := &recordingClient{reject: "mem.free"}
+	server := httptest.NewServer(NewHandler(client, Options{}))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/write?precision=ms", "text/plain", strings.NewReader("cpu idle=98 1000\nmem free=2 1000\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || !strings.HasPrefix(resp.Header.Get("X-Influxdb-Error"), "partial write") {
+		t.Errorf("Expected a partial write error, got status code %d", resp.StatusCode)
+	}
+	if len(client.series) != 1 || client.series[0].Name != "cpu.idle" {
+		t.Errorf("Expected the valid series to be stored, got %v", client.series)
+	}
+}
+
+func TestHandlerRenamesReservedTagsOnElastic(t *testing.T) {
+	var bodies [][]byte
+	elasticServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal("Error reading request body:", err)
+		}
+		bodies = append(bodies, body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer elasticServer.Close()
+	elastic, err := chronix.NewElasticTestStorage(&elasticServer.URL)
+	if err != nil {
+		t.Fatal("Error creating Elastic storage:", err)
+	}
+	server := httptest.NewServer(NewHandler(chronix.NewClient(elastic), Options{}))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/write", "text/plain", strings.NewReader("cpu,type=node idle=98\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Unexpected status code %d", resp.StatusCode)
+	}
+	if len(bodies) != 1 || !bytes.Contains(bodies[0], []byte(`"exported_type":"node"`)) {
+		t.Errorf("Expected the type tag to be stored as exported_type, got %q", bodies)
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	server := httptest.NewServer(NewHandler(&recordingClient{}, Options{MaxRequestSize: 64}))
+	defer server.Close()
+
+	tests := []struct {
+		query string
+		body  string
+		want  int
+	}{
+		{query: "precision=d", body: "cpu idle=1", want: http.StatusBadRequest},
+		{body: "cpu idle=", want: http.StatusBadRequest},
+		{body: strings.Repeat("cpu idle=1\n", 10), want: http.StatusRequestEntityTooLarge},
+	}
+	for i, test := range tests {
+		resp, err := http.Post(server.URL+"/write?"+test.query, "text/plain", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.want {
+			t.Errorf("%d. Expected status code %d, got %d", i, test.want, resp.StatusCode)
+		}
+		if resp.Header.Get("X-Influxdb-Error") == "" {
+			t.Errorf("%d. Expected an error header", i)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Package influx implements the ingestion of the InfluxDB line protocol into Chronix.
+package influx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// A Point is a point of the line protocol with all its fields.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+	// Timestamp is the time of the point in nanoseconds since the epoch.
+	Timestamp int64
+}
+
+// A Field is a field of a point. Its value is a float64, int64, uint64, bool or string.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// A ParseError describes an invalid line.
+type ParseError struct {
+	// Line is the number of the line, starting at 1.
+	Line int
+	// Err is the cause of the error.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("error parsing line %d: %v", e.Line, e.Err)
+}
+
+// precisions are the precision parameters of the InfluxDB 1.x and 2.x write APIs.
+var precisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"µ":  time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// ParsePrecision returns the unit of timestamps for a precision parameter such as
+// "ns", "ms" or "s". An empty precision means nanoseconds.
+func ParsePrecision(precision string) (time.Duration, error) {
+	unit, ok := precisions[precision]
+	if !ok {
+		return 0, fmt.Errorf("invalid precision '%s'", precision)
+	}
+	return unit, nil
+}
+
+// Parse parses the lines of the line protocol. Timestamps are given in the unit
+// of precision; points without a timestamp get the time now. Empty lines and
+// comments are skipped. A *ParseError is returned for the first invalid line.
+func Parse(r io.Reader, precision time.Duration, now time.Time) ([]Point, error) {
+	var points []Point
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for n := 1; s.Scan(); n++ {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		p, err := parseLine(line, precision, now)
+		if err != nil {
+			return nil, &ParseError{Line: n, Err: err}
+		}
+		points = append(points, p)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("error reading lines: %v", err)
+	}
+	return points, nil
+}
+
+// parseLine parses one line of the form
+// "measurement[,tag=value...] field=value[,field=value...] [timestamp]".
+func parseLine(line []byte, precision time.Duration, now time.Time) (Point, error) {
+	p := Point{Tags: map[string]string{}}
+
+	var i int
+	p.Measurement, i = scanToken(line, 0, ", ")
+	if p.Measurement == "" {
+		return p, errors.New("missing measurement")
+	}
+
+	for i < len(line) && line[i] == ',' {
+		var key, value string
+		key, i = scanToken(line, i+1, ",= ")
+		if key == "" || i >= len(line) || line[i] != '=' {
+			return p, errors.New("invalid tag")
+		}
+		value, i = scanToken(line, i+1, ", ")
+		if value == "" {
+			return p, fmt.Errorf("missing value of tag '%s'", key)
+		}
+		p.Tags[key] = value
+	}
+
+	i = skipSpaces(line, i)
+	for {
+		var key string
+		key, i = scanToken(line, i, ",= ")
+		if key == "" || i >= len(line) || line[i] != '=' {
+			return p, errors.New("invalid field")
+		}
+		value, next, err := scanFieldValue(line, i+1)
+		if err != nil {
+			return p, fmt.Errorf("invalid value of field '%s': %v", key, err)
+		}
+		p.Fields = append(p.Fields, Field{Key: key, Value: value})
+		i = next
+		if i >= len(line) || line[i] != ',' {
+			break
+		}
+		i++
+	}
+
+	i = skipSpaces(line, i)
+	if i == len(line) {
+		p.Timestamp = now.UnixNano()
+		return p, nil
+	}
+	ts, err := strconv.ParseInt(string(line[i:]), 10, 64)
+	if err != nil {
+		return p, fmt.Errorf("invalid timestamp '%s'", line[i:])
+	}
+	if ts > math.MaxInt64/int64(precision) || ts < math.MinInt64/int64(precision) {
+		return p, fmt.Errorf("timestamp '%s' out of range", line[i:])
+	}
+	p.Timestamp = ts * int64(precision)
+	return p, nil
+}
+
+// scanToken scans an unquoted token up to one of the stop characters. Backslashes
+// escape the stop characters and themselves; other backslashes are kept.
+func scanToken(line []byte, i int, stops string) (string, int) {
+	var buf bytes.Buffer
+	for ; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' && i+1 < len(line) && (line[i+1] == '\\' || bytes.IndexByte([]byte(stops), line[i+1]) >= 0) {
+			i++
+			buf.WriteByte(line[i])
+			continue
+		}
+		if bytes.IndexByte([]byte(stops), c) >= 0 {
+			break
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String(), i
+}
+
+// scanFieldValue scans and parses a field value.
+func scanFieldValue(line []byte, i int) (interface{}, int, error) {
+	if i < len(line) && line[i] == '"' {
+		var buf bytes.Buffer
+		for i++; i < len(line); i++ {
+			c := line[i]
+			if c == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+				i++
+				buf.WriteByte(line[i])
+				continue
+			}
+			if c == '"' {
+				return buf.String(), i + 1, nil
+			}
+			buf.WriteByte(c)
+		}
+		return nil, i, errors.New("unterminated string")
+	}
+
+	start := i
+	for i < len(line) && line[i] != ',' && line[i] != ' ' {
+		i++
+	}
+	v, err := parseFieldValue(string(line[start:i]))
+	return v, i, err
+}
+
+// parseFieldValue parses an unquoted field value.
+func parseFieldValue(s string) (interface{}, error) {
+	switch s {
+	case "":
+		return nil, errors.New("missing value")
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+
+	switch s[len(s)-1] {
+	case 'i':
+		v, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer '%s'", s)
+		}
+		return v, nil
+	case 'u':
+		v, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unsigned integer '%s'", s)
+		}
+		return v, nil
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("invalid number '%s'", s)
+	}
+	return v, nil
+}
+
+func skipSpaces(line []byte, i int) int {
+	for i < len(line) && line[i] == ' ' {
+		i++
+	}
+	return i
+}
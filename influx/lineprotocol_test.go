@@ -0,0 +1,106 @@
+package influx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Unix(1470784794, 0)
+
+	tests := []struct {
+		input     string
+		precision time.Duration
+		want      []Point
+	}{
+		{
+			input: "cpu,host=web-01,region=eu usage_idle=98.5,usage_user=1i 1470784794000000000",
+			want: []Point{{
+				Measurement: "cpu",
+				Tags:        map[string]string{"host": "web-01", "region": "eu"},
+				Fields:      []Field{{"usage_idle", 98.5}, {"usage_user", int64(1)}},
+				Timestamp:   1470784794000000000,
+			}},
+		},
+		{
+			input:     "# comment\n\nmem free=10u,ok=t,msg=\"a \\\"quoted\\\", string\" 1470784794\r\n",
+			precision: time.Second,
+			want: []Point{{
+				Measurement: "mem",
+				Tags:        map[string]string{},
+				Fields:      []Field{{"free", uint64(10)}, {"ok", true}, {"msg", `a "quoted", string`}},
+				Timestamp:   1470784794000000000,
+			}},
+		},
+		{
+			input: `disk\ io,path=C:\\,dev\,ice=sd\ a read\=s=-1.5e3`,
+			want: []Point{{
+				Measurement: "disk io",
+				Tags:        map[string]string{"path": `C:\`, "dev,ice": "sd a"},
+				Fields:      []Field{{"read=s", -1500.0}},
+				Timestamp:   now.UnixNano(),
+			}},
+		},
+	}
+
+	for i, test := range tests {
+		precision := test.precision
+		if precision == 0 {
+			precision = time.Nanosecond
+		}
+		got, err := Parse(strings.NewReader(test.input), precision, now)
+		if err != nil {
+			t.Fatalf("%d. Error parsing lines: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d. Unexpected points. Want %+v, got %+v", i, test.want, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		",host=a value=1",
+		"cpu,host value=1",
+		"cpu,host= value=1",
+		"cpu",
+		"cpu value",
+		"cpu value=",
+		"cpu value=abc",
+		"cpu value=NaN",
+		"cpu value=1.5i",
+		`cpu value="unterminated`,
+		"cpu value=1 yesterday",
+		"cpu value=1 1 2",
+	}
+	for _, line := range tests {
+		_, err := Parse(strings.NewReader("cpu value=1\n"+line), time.Nanosecond, time.Now())
+		if e, ok := err.(*ParseError); !ok || e.Line != 2 {
+			t.Errorf("Expected a *ParseError for line 2 %q, got %v", line, err)
+		}
+	}
+
+	// Timestamps in seconds that overflow when converted to nanoseconds.
+	for _, line := range []string{"cpu value=1 9223372037", "cpu value=1 -9223372037"} {
+		_, err := Parse(strings.NewReader(line), time.Second, time.Now())
+		if _, ok := err.(*ParseError); !ok {
+			t.Errorf("Expected a *ParseError for %q, got %v", line, err)
+		}
+	}
+	if _, err := Parse(strings.NewReader("cpu value=1 9223372036"), time.Second, time.Now()); err != nil {
+		t.Error("Unexpected error for the largest timestamp in seconds:", err)
+	}
+}
+
+func TestParsePrecision(t *testing.T) {
+	for precision, want := range map[string]time.Duration{"": time.Nanosecond, "u": time.Microsecond, "ms": time.Millisecond, "h": time.Hour} {
+		if got, err := ParsePrecision(precision); err != nil || got != want {
+			t.Errorf("Unexpected unit of precision %q. Want %v, got %v (%v)", precision, want, got, err)
+		}
+	}
+	if _, err := ParsePrecision("d"); err == nil {
+		t.Error("Expected an error for an invalid precision")
+	}
+}
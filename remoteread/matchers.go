@@ -0,0 +1,172 @@
+package remoteread
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// A matcher is a compiled Prometheus label matcher.
+type matcher struct {
+	*prompb.LabelMatcher
+	re *regexp.Regexp
+}
+
+type matchers []matcher
+
+// newMatchers compiles the label matchers of a query.
+func newMatchers(lms []*prompb.LabelMatcher) (matchers, error) {
+	m := make(matchers, 0, len(lms))
+	for _, lm := range lms {
+		var re *regexp.Regexp
+		switch lm.Type {
+		case prompb.LabelMatcher_EQ, prompb.LabelMatcher_NEQ:
+		case prompb.LabelMatcher_RE, prompb.LabelMatcher_NRE:
+			var err error
+			// Prometheus regular expressions are anchored.
+			re, err = regexp.Compile("^(?:" + lm.Value + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression for label %s: %v", lm.Name, err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown matcher type %v for label %s", lm.Type, lm.Name)
+		}
+		m = append(m, matcher{lm, re})
+	}
+	return m, nil
+}
+
+// matches reports whether a label value matches. Missing labels have an empty value.
+func (m matcher) matches(value string) bool {
+	switch m.Type {
+	case prompb.LabelMatcher_EQ:
+		return value == m.Value
+	case prompb.LabelMatcher_NEQ:
+		return value != m.Value
+	case prompb.LabelMatcher_RE:
+		return m.re.MatchString(value)
+	default:
+		return !m.re.MatchString(value)
+	}
+}
+
+// matches reports whether a label set matches all matchers.
+func (ms matchers) matches(labels []prompb.Label) bool {
+	for _, m := range ms {
+		value := ""
+		for _, l := range labels {
+			if l.Name == m.Name {
+				value = l.Value
+				break
+			}
+		}
+		if !m.matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply adds the matchers to a query. Matchers that match missing labels cannot
+// be expressed by a positive query clause, so they are only applied to the
+// returned series. The syntax of regular expressions differs between Prometheus
+// and Lucene, so only literals, alternations of literals and prefixes (e.g.
+// "a|b" or "a.*") are added; the others are only applied to the returned series.
+func (ms matchers) apply(b *chronix.QueryBuilder) {
+	for _, m := range ms {
+		attribute := m.Name
+		switch attribute {
+		case nameLabel:
+			attribute = "name"
+		case "name", "type":
+			// These labels are stored like other attributes, but the query
+			// builder would refer to the name and type of the series.
+			continue
+		}
+		if m.Value == "" {
+			continue
+		}
+		switch m.Type {
+		case prompb.LabelMatcher_EQ:
+			b.AttributeEquals(attribute, m.Value)
+		case prompb.LabelMatcher_NEQ:
+			b.AttributeNotEquals(attribute, m.Value)
+		case prompb.LabelMatcher_RE:
+			if m.matches("") {
+				continue
+			}
+			if lits, ok := literals(m.Value); ok && len(lits) == 1 {
+				b.AttributeEquals(attribute, lits[0])
+			} else if ok {
+				b.AttributeRegex(attribute, alternation(lits))
+			} else if prefix, ok := literalPrefix(m.Value); ok {
+				b.AttributePrefix(attribute, prefix)
+			}
+		case prompb.LabelMatcher_NRE:
+			// A negated prefix would also exclude values with line breaks, which
+			// "." does not match.
+			if lits, ok := literals(m.Value); ok && len(lits) == 1 {
+				b.AttributeNotEquals(attribute, lits[0])
+			} else if ok {
+				b.AttributeNotRegex(attribute, alternation(lits))
+			}
+		}
+	}
+}
+
+// literals returns the strings matched by a regular expression that is a literal
+// or an alternation of literals, e.g. "a|b".
+func literals(re string) ([]string, bool) {
+	parts := strings.Split(re, "|")
+	lits := make([]string, 0, len(parts))
+	for _, part := range parts {
+		lit, ok := literal(part)
+		if !ok {
+			return nil, false
+		}
+		lits = append(lits, lit)
+	}
+	return lits, true
+}
+
+// literalPrefix returns the prefix of a regular expression that matches a literal
+// followed by anything, e.g. "a.*".
+func literalPrefix(re string) (string, bool) {
+	if !strings.HasSuffix(re, ".*") {
+		return "", false
+	}
+	return literal(strings.TrimSuffix(re, ".*"))
+}
+
+// literal returns the string matched by a regular expression without special
+// characters other than escaped ones, e.g. "a\.b".
+func literal(re string) (string, bool) {
+	parsed, err := syntax.Parse(re, syntax.Perl)
+	if err != nil || parsed.Op != syntax.OpLiteral || parsed.Flags&syntax.FoldCase != 0 {
+		return "", false
+	}
+	return string(parsed.Rune), true
+}
+
+// alternation returns a Lucene regular expression matching any of the literals.
+// The query builder escapes slashes.
+func alternation(lits []string) string {
+	parts := make([]string, 0, len(lits))
+	for _, lit := range lits {
+		var buf bytes.Buffer
+		for _, c := range lit {
+			if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' && c != '/' {
+				buf.WriteByte('\\')
+			}
+			buf.WriteRune(c)
+		}
+		parts = append(parts, buf.String())
+	}
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,276 @@
+// Package remoteread implements the Prometheus remote_read protocol on top of
+// Chronix queries, so that Prometheus can use Chronix as long-term storage.
+package remoteread
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// DefaultMaxRequestSize is the default limit of the compressed size of a request.
+const DefaultMaxRequestSize = 1 << 20
+
+// DefaultType is the default type of the queried series.
+const DefaultType = "metric"
+
+// nameLabel is the label holding the metric name.
+const nameLabel = "__name__"
+
+// ErrRequestTooLarge is returned for requests exceeding the maximum request size.
+var ErrRequestTooLarge = errors.New("request is too large")
+
+// ErrUnsupportedResponseType is returned for requests that do not accept sampled responses.
+var ErrUnsupportedResponseType = errors.New("only sampled responses are supported")
+
+// Options configures a Handler.
+type Options struct {
+	// Type is the type of the queried series. If it is empty, DefaultType is used.
+	Type string
+	// MaxRequestSize is the maximum compressed size of a request in bytes.
+	// If it is 0, DefaultMaxRequestSize is used.
+	MaxRequestSize int64
+}
+
+// A Handler is an http.Handler that answers Prometheus remote_read requests with
+// the series stored in Chronix.
+//
+// The label matchers of a query are translated into a Chronix query. The name of a
+// series is its __name__ label and the attributes are the other labels. Regular
+// expressions are only passed to the storage if they are literals, alternations
+// of literals or prefixes, as the syntax of Lucene differs. The matchers are
+// applied again to the returned series, which gives them the Prometheus
+// semantics, e.g. for empty values.
+type Handler struct {
+	storage chronix.StorageClient
+	client  chronix.Client
+	opts    Options
+}
+
+// NewHandler creates a new Handler that queries the storage.
+func NewHandler(s chronix.StorageClient, opts Options) *Handler {
+	if opts.Type == "" {
+		opts.Type = DefaultType
+	}
+	if opts.MaxRequestSize <= 0 {
+		opts.MaxRequestSize = DefaultMaxRequestSize
+	}
+	return &Handler{
+		storage: s,
+		client:  chronix.New(s),
+		opts:    opts,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := DecodeRequest(r.Body, h.opts.MaxRequestSize)
+	if err == ErrRequestTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !acceptsSamples(req) {
+		http.Error(w, ErrUnsupportedResponseType.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := &prompb.ReadResponse{
+		Results: make([]*prompb.QueryResult, 0, len(req.Queries)),
+	}
+	for _, q := range req.Queries {
+		m, err := newMatchers(q.Matchers)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		series, err := h.query(r.Context(), q, m)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.Results = append(resp.Results, &prompb.QueryResult{Timeseries: series})
+	}
+
+	body, err := EncodeResponse(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Encoding", "snappy")
+	w.Write(body)
+}
+
+// query returns the series matching a query with the points in its time range.
+func (h *Handler) query(ctx context.Context, q *prompb.Query, m matchers) ([]*prompb.TimeSeries, error) {
+	b := chronix.NewQueryBuilder().
+		Type(h.opts.Type).
+		Range(fromMillis(q.StartTimestampMs), fromMillis(q.EndTimestampMs))
+	m.apply(b)
+	params, err := b.Build(h.storage.NeedPostfixOnDynamicField())
+	if err != nil {
+		return nil, fmt.Errorf("error building query: %v", err)
+	}
+
+	// The chunks are requested page by page, as a single request returns the
+	// first page only.
+	it := h.client.IterateSeries(ctx, params, 0)
+	defer it.Close()
+
+	// Merge the chunks of each series.
+	bySeries := map[string]*prompb.TimeSeries{}
+	for it.Next() {
+		chunk := it.Series()
+		labels := toLabels(chunk)
+		if !m.matches(labels) {
+			continue
+		}
+		key := labelsKey(labels)
+		ts, ok := bySeries[key]
+		if !ok {
+			ts = &prompb.TimeSeries{Labels: labels}
+			bySeries[key] = ts
+		}
+		for _, p := range chunk.Points {
+			if p.Timestamp >= q.StartTimestampMs && p.Timestamp <= q.EndTimestampMs {
+				ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: p.Timestamp, Value: p.Value})
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("error querying storage: %v", err)
+	}
+
+	keys := make([]string, 0, len(bySeries))
+	for key, ts := range bySeries {
+		if len(ts.Samples) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	series := make([]*prompb.TimeSeries, 0, len(keys))
+	for _, key := range keys {
+		ts := bySeries[key]
+		ts.Samples = sortSamples(ts.Samples)
+		series = append(series, ts)
+	}
+	return series, nil
+}
+
+// toLabels returns the labels of a chunk sorted by their names.
+func toLabels(ts *chronix.TimeSeries) []prompb.Label {
+	labels := make([]prompb.Label, 0, len(ts.Attributes)+1)
+	labels = append(labels, prompb.Label{Name: nameLabel, Value: ts.Name})
+	for name, value := range ts.Attributes {
+		if value != "" {
+			labels = append(labels, prompb.Label{Name: name, Value: value})
+		}
+	}
+	sort.Sort(byName(labels))
+	return labels
+}
+
+// labelsKey returns a string identifying a sorted label set.
+func labelsKey(labels []prompb.Label) string {
+	parts := make([]string, 0, 2*len(labels))
+	for _, l := range labels {
+		parts = append(parts, l.Name, l.Value)
+	}
+	return strings.Join(parts, "\xff")
+}
+
+// sortSamples sorts samples by their timestamps and removes the duplicates of
+// overlapping chunks.
+func sortSamples(samples []prompb.Sample) []prompb.Sample {
+	sort.Stable(byTimestamp(samples))
+	result := samples[:0]
+	for i, s := range samples {
+		if i > 0 && s.Timestamp == samples[i-1].Timestamp {
+			continue
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+type byName []prompb.Label
+
+func (l byName) Len() int           { return len(l) }
+func (l byName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+func (l byName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+type byTimestamp []prompb.Sample
+
+func (s byTimestamp) Len() int           { return len(s) }
+func (s byTimestamp) Less(i, j int) bool { return s[i].Timestamp < s[j].Timestamp }
+func (s byTimestamp) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// fromMillis converts milliseconds since the epoch to a time.
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// acceptsSamples reports whether the response to the request may contain samples.
+func acceptsSamples(req *prompb.ReadRequest) bool {
+	if len(req.AcceptedResponseTypes) == 0 {
+		return true
+	}
+	for _, t := range req.AcceptedResponseTypes {
+		if t == prompb.ReadRequest_SAMPLES {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeRequest reads a snappy-compressed remote_read request of at most
+// maxSize bytes. ErrRequestTooLarge is returned for larger requests.
+func DecodeRequest(r io.Reader, maxSize int64) (*prompb.ReadRequest, error) {
+	compressed, err := ioutil.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading request: %v", err)
+	}
+	if int64(len(compressed)) > maxSize {
+		return nil, ErrRequestTooLarge
+	}
+
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing request: %v", err)
+	}
+
+	var req prompb.ReadRequest
+	if err := req.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("error unmarshalling request: %v", err)
+	}
+	return &req, nil
+}
+
+// EncodeResponse encodes a remote_read response the way Prometheus expects it.
+func EncodeResponse(resp *prompb.ReadResponse) ([]byte, error) {
+	data, err := resp.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling response: %v", err)
+	}
+	return snappy.Encode(nil, data), nil
+}
@@ -0,0 +1,334 @@
+package remoteread
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// solrMock answers Solr select requests with fixed chunks and records the queries.
+// Like Solr, it returns 10 documents unless more rows are requested, and pages
+// through the documents with a cursor, which is the offset of the next page.
+type solrMock struct {
+	t    *testing.T
+	docs []map[string]interface{}
+
+	mtx     sync.Mutex
+	queries []string
+}
+
+func (m *solrMock) addChunk(name string, attributes map[string]string, points []chronix.Point) {
+	data, err := chronix.EncodePoints(points, 0)
+	if err != nil {
+		m.t.Fatal("Error encoding points:", err)
+	}
+	doc := map[string]interface{}{
+		"name":  name,
+		"type":  "metric",
+		"start": points[0].Timestamp,
+		"end":   points[len(points)-1].Timestamp,
+		"data":  base64.StdEncoding.EncodeToString(data),
+	}
+	for k, v := range attributes {
+		doc[k+"_s"] = v
+	}
+	m.docs = append(m.docs, doc)
+}
+
+func (m *solrMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	m.mtx.Lock()
+	m.queries = append(m.queries, qs.Get("q"))
+	m.mtx.Unlock()
+
+	rows := 10
+	if qs.Get("rows") != "" {
+		fmt.Sscanf(qs.Get("rows"), "%d", &rows)
+	}
+	cursor := qs.Get("cursorMark")
+	offset := 0
+	if cursor != "" && cursor != "*" {
+		fmt.Sscanf(cursor, "%d", &offset)
+	}
+	end := offset + rows
+	if end > len(m.docs) {
+		end = len(m.docs)
+	}
+
+	resp := map[string]interface{}{
+		"response": map[string]interface{}{
+			"numFound": len(m.docs),
+			"docs":     m.docs[offset:end],
+		},
+	}
+	if cursor != "" {
+		next := fmt.Sprint(end)
+		if offset == end {
+			next = cursor
+		}
+		resp["nextCursorMark"] = next
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		m.t.Error("Error encoding response:", err)
+	}
+}
+
+func newTestServer(t *testing.T, solr *solrMock) (*httptest.Server, func()) {
+	solrServer := httptest.NewServer(solr)
+	u, err := url.Parse(solrServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewHandler(chronix.NewSolr(u), Options{}))
+	return server, func() {
+		server.Close()
+		solrServer.Close()
+	}
+}
+
+// read sends a remote_read request like Prometheus does.
+func read(t *testing.T, server *httptest.Server, req *prompb.ReadRequest) (*http.Response, *prompb.ReadResponse) {
+	data, err := req.Marshal()
+	if err != nil {
+		t.Fatal("Error marshalling request:", err)
+	}
+	resp, err := http.Post(server.URL, "application/x-protobuf", bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		t.Fatal("Error sending request:", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	compressed, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("Error reading response:", err)
+	}
+	data, err = snappy.Decode(nil, compressed)
+	if err != nil {
+		t.Fatal("Error decompressing response:", err)
+	}
+	var readResp prompb.ReadResponse
+	if err := readResp.Unmarshal(data); err != nil {
+		t.Fatal("Error unmarshalling response:", err)
+	}
+	return resp, &readResp
+}
+
+func TestHandler(t *testing.T) {
+	solr := &solrMock{t: t}
+	solr.addChunk("up", map[string]string{"job": "node", "instance": "a"},
+		[]chronix.Point{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 1}, {Timestamp: 3000, Value: 0}})
+	solr.addChunk("up", map[string]string{"job": "node", "instance": "a"},
+		[]chronix.Point{{Timestamp: 3000, Value: 0}, {Timestamp: 4000, Value: 1}, {Timestamp: 5000, Value: 1}})
+	solr.addChunk("up", map[string]string{"job": "node", "instance": "b"},
+		[]chronix.Point{{Timestamp: 2000, Value: 1}})
+	solr.addChunk("up", map[string]string{"job": "prometheus", "instance": "a"},
+		[]chronix.Point{{Timestamp: 2000, Value: 1}})
+	server, stop := newTestServer(t, solr)
+	defer stop()
+
+	resp, readResp := read(t, server, &prompb.ReadRequest{
+		Queries: []*prompb.Query{{
+			StartTimestampMs: 2000,
+			EndTimestampMs:   4000,
+			Matchers: []*prompb.LabelMatcher{
+				{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+				{Type: prompb.LabelMatcher_NEQ, Name: "instance", Value: "b"},
+				{Type: prompb.LabelMatcher_RE, Name: "job", Value: "node|other"},
+				{Type: prompb.LabelMatcher_NRE, Name: "env", Value: "dev.*"},
+				{Type: prompb.LabelMatcher_EQ, Name: "dc", Value: ""},
+			},
+		}},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status code %d", resp.StatusCode)
+	}
+
+	wantQuery := `type:"metric" AND name:"up" AND -instance_s:"b" AND job_s:/node|other/ AND start:2000 AND end:4000`
+	if len(solr.queries) != 2 || solr.queries[0] != wantQuery {
+		t.Errorf("Unexpected queries. Want %s, got %v", wantQuery, solr.queries)
+	}
+
+	want := &prompb.ReadResponse{
+		Results: []*prompb.QueryResult{{
+			Timeseries: []*prompb.TimeSeries{{
+				Labels: []prompb.Label{
+					{Name: "__name__", Value: "up"},
+					{Name: "instance", Value: "a"},
+					{Name: "job", Value: "node"},
+				},
+				Samples: []prompb.Sample{
+					{Timestamp: 2000, Value: 1},
+					{Timestamp: 3000, Value: 0},
+					{Timestamp: 4000, Value: 1},
+				},
+			}},
+		}},
+	}
+	if !reflect.DeepEqual(readResp, want) {
+		t.Fatalf("Unexpected response. Want:\n\n%v\n\nGot:\n\n%v", want, readResp)
+	}
+}
+
+func TestHandlerFiltersRegexClientSide(t *testing.T) {
+	solr := &solrMock{t: t}
+	solr.addChunk("up", map[string]string{"instance": "10"}, []chronix.Point{{Timestamp: 1000, Value: 1}})
+	solr.addChunk("up", map[string]string{"instance": "a"}, []chronix.Point{{Timestamp: 1000, Value: 1}})
+	server, stop := newTestServer(t, solr)
+	defer stop()
+
+	resp, readResp := read(t, server, &prompb.ReadRequest{
+		Queries: []*prompb.Query{{
+			StartTimestampMs: 1000,
+			EndTimestampMs:   1000,
+			Matchers: []*prompb.LabelMatcher{
+				{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+				{Type: prompb.LabelMatcher_RE, Name: "instance", Value: `\d+`},
+			},
+		}},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status code %d", resp.StatusCode)
+	}
+
+	wantQuery := `type:"metric" AND name:"up" AND start:1000 AND end:1000`
+	if len(solr.queries) == 0 || solr.queries[0] != wantQuery {
+		t.Errorf("Unexpected queries. Want %s, got %v", wantQuery, solr.queries)
+	}
+	series := readResp.Results[0].Timeseries
+	if len(series) != 1 || series[0].Labels[1].Value != "10" {
+		t.Fatalf("Expected the series with a numeric instance only, got %v", series)
+	}
+}
+
+func TestMatchersApply(t *testing.T) {
+	tests := []struct {
+		matcher *prompb.LabelMatcher
+		want    string
+	}{
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_RE, Name: "job", Value: "node"}, `job_s:"node"`},
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_RE, Name: "job", Value: `a\.b|c/d`}, `job_s:/a\.b|c\/d/`},
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_RE, Name: "job", Value: "no.*"}, `job_s:no*`},
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_RE, Name: "job", Value: `\d+`}, `*:*`},
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_RE, Name: "job", Value: "(?i)node"}, `*:*`},
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_NRE, Name: "job", Value: "a|b"}, `-job_s:/a|b/`},
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_NRE, Name: "job", Value: "no.*"}, `*:*`},
+	}
+
+	for i, test := range tests {
+		m, err := newMatchers([]*prompb.LabelMatcher{test.matcher})
+		if err != nil {
+			t.Fatalf("%d. Error compiling matcher: %v", i, err)
+		}
+		b := chronix.NewQueryBuilder()
+		m.apply(b)
+		params, err := b.Build(true)
+		if err != nil {
+			t.Fatalf("%d. Error building query: %v", i, err)
+		}
+		if params.Q != test.want {
+			t.Errorf("%d. Unexpected query for %v. Want %s, got %s", i, test.matcher, test.want, params.Q)
+		}
+	}
+}
+
+func TestHandlerReadsAllPages(t *testing.T) {
+	solr := &solrMock{t: t}
+	var samples []prompb.Sample
+	for i := int64(0); i < 25; i++ {
+		solr.addChunk("up", map[string]string{"job": "node"}, []chronix.Point{{Timestamp: 1000 * i, Value: float64(i)}})
+		samples = append(samples, prompb.Sample{Timestamp: 1000 * i, Value: float64(i)})
+	}
+	server, stop := newTestServer(t, solr)
+	defer stop()
+
+	resp, readResp := read(t, server, &prompb.ReadRequest{
+		Queries: []*prompb.Query{{
+			StartTimestampMs: 0,
+			EndTimestampMs:   24000,
+			Matchers:         []*prompb.LabelMatcher{{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"}},
+		}},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status code %d", resp.StatusCode)
+	}
+	if len(readResp.Results) != 1 || len(readResp.Results[0].Timeseries) != 1 {
+		t.Fatalf("Expected one series, got %v", readResp)
+	}
+	if got := readResp.Results[0].Timeseries[0].Samples; !reflect.DeepEqual(got, samples) {
+		t.Fatalf("Unexpected samples. Want %v, got %v", samples, got)
+	}
+}
+
+func TestHandlerRejectsInvalidRequests(t *testing.T) {
+	server, stop := newTestServer(t, &solrMock{t: t})
+	defer stop()
+
+	requests := []*prompb.ReadRequest{
+		{
+			Queries:               []*prompb.Query{{}},
+			AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS},
+		},
+		{
+			Queries: []*prompb.Query{{
+				Matchers: []*prompb.LabelMatcher{{Type: prompb.LabelMatcher_RE, Name: "job", Value: "("}},
+			}},
+		},
+	}
+	for i, req := range requests {
+		if resp, _ := read(t, server, req); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%d. Expected status code %d, got %d", i, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET requests to be rejected, got status code %d", resp.StatusCode)
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	labels := []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}}
+
+	tests := []struct {
+		matcher *prompb.LabelMatcher
+		want    bool
+	}{
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_EQ, Name: "job", Value: "node"}, true},
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_EQ, Name: "dc", Value: ""}, true},
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_NEQ, Name: "job", Value: ""}, true},
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_NEQ, Name: "dc", Value: ""}, false},
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_RE, Name: "job", Value: "no"}, false},
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_RE, Name: "dc", Value: ".*"}, true},
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_NRE, Name: "job", Value: "n.*"}, false},
+		{&prompb.LabelMatcher{Type: prompb.LabelMatcher_NRE, Name: "dc", Value: ".+"}, true},
+	}
+
+	for i, test := range tests {
+		m, err := newMatchers([]*prompb.LabelMatcher{test.matcher})
+		if err != nil {
+			t.Fatalf("%d. Error compiling matcher: %v", i, err)
+		}
+		if got := m.matches(labels); got != test.want {
+			t.Errorf("%d. Unexpected match of %v. Want %t, got %t", i, test.matcher, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Package remotewrite implements a receiver for the Prometheus remote_write
+// protocol that stores the received samples in Chronix.
+package remotewrite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// DefaultMaxRequestSize is the default limit of the compressed size of a request.
+const DefaultMaxRequestSize = 32 << 20
+
+// DefaultType is the default type of the stored series.
+const DefaultType = "metric"
+
+// nameLabel is the label holding the metric name.
+const nameLabel = "__name__"
+
+// ReservedLabelPrefix is prepended to labels named like a field of the chunk
+// documents, e.g. "type" is stored as "exported_type", like Prometheus does for
+// conflicting target labels.
+const ReservedLabelPrefix = "exported_"
+
+// staleNaN is the bit pattern of the NaN value Prometheus uses to mark series as stale.
+const staleNaN uint64 = 0x7ff0000000000002
+
+// ErrMissingName is returned for series without a metric name label.
+var ErrMissingName = errors.New("series has no __name__ label")
+
+// ErrRequestTooLarge is returned for requests exceeding the maximum request size.
+var ErrRequestTooLarge = errors.New("request is too large")
+
+// Options configures a Handler.
+type Options struct {
+	// Type is the type of the stored series. If it is empty, DefaultType is used.
+	Type string
+	// MaxRequestSize is the maximum compressed size of a request in bytes.
+	// If it is 0, DefaultMaxRequestSize is used.
+	MaxRequestSize int64
+	// KeepStaleMarkers stores the staleness markers of Prometheus as NaN values.
+	// By default, they are dropped.
+	KeepStaleMarkers bool
+	// CommitWithin is passed to the client when storing series.
+	CommitWithin time.Duration
+	// Buffer enables buffering the samples in a chronix.BufferedWriter, which
+	// stores them in chunks of many requests. Requests are answered once their
+	// samples are buffered, so samples that cannot be stored later are not
+	// retried by Prometheus but reported through Buffer.OnError. If it is nil,
+	// the samples of a request are stored before it is answered, which makes one
+	// chunk per series and request.
+	Buffer *chronix.BufferedWriterOptions
+}
+
+// A Handler is an http.Handler that accepts Prometheus remote_write requests and
+// stores the received samples in Chronix. Requests that could not be stored fail
+// with a server error and are retried by Prometheus. Series the storage rejects
+// are dropped and reported with a client error, the other series of the request
+// are stored.
+type Handler struct {
+	client chronix.Client
+	writer *chronix.BufferedWriter
+	opts   Options
+}
+
+// NewHandler creates a new Handler that stores the samples through the client.
+func NewHandler(c chronix.Client, opts Options) *Handler {
+	if opts.Type == "" {
+		opts.Type = DefaultType
+	}
+	if opts.MaxRequestSize <= 0 {
+		opts.MaxRequestSize = DefaultMaxRequestSize
+	}
+	h := &Handler{
+		client: c,
+		opts:   opts,
+	}
+	if opts.Buffer != nil {
+		bopts := *opts.Buffer
+		if bopts.ReservedAttributePrefix == "" {
+			bopts.ReservedAttributePrefix = ReservedLabelPrefix
+		}
+		h.writer = chronix.NewBufferedWriter(c, bopts)
+	}
+	return h
+}
+
+// Close stores the buffered samples if buffering is enabled.
+func (h *Handler) Close() error {
+	if h.writer == nil {
+		return nil
+	}
+	return h.writer.Close()
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := DecodeRequest(r.Body, h.opts.MaxRequestSize)
+	if err == ErrRequestTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series, err := h.toTimeSeries(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.writer != nil {
+		for _, ts := range series {
+			if err := h.writer.WriteContext(r.Context(), ts); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	invalid, err := chronix.StoreEach(r.Context(), h.client, series, false, h.opts.CommitWithin, ReservedLabelPrefix)
+	if err != nil {
+		// Prometheus retries requests failing with a server error.
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(invalid) > 0 {
+		// Prometheus drops requests failing with a client error, the valid series
+		// have been stored.
+		msgs := make([]string, len(invalid))
+		for i, e := range invalid {
+			msgs[i] = e.Error()
+		}
+		http.Error(w, strings.Join(msgs, "\n"), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// toTimeSeries converts the series of a write request. The metric name becomes
+// the name of the series and the other labels become its attributes. Prometheus
+// and Chronix both use timestamps in milliseconds since the epoch.
+func (h *Handler) toTimeSeries(req *prompb.WriteRequest) ([]*chronix.TimeSeries, error) {
+	series := make([]*chronix.TimeSeries, 0, len(req.Timeseries))
+	for _, pts := range req.Timeseries {
+		ts := &chronix.TimeSeries{
+			Type:       h.opts.Type,
+			Attributes: make(map[string]string, len(pts.Labels)),
+			Points:     make([]chronix.Point, 0, len(pts.Samples)),
+		}
+		for _, l := range pts.Labels {
+			if l.Name == nameLabel {
+				ts.Name = l.Value
+			} else {
+				ts.Attributes[l.Name] = l.Value
+			}
+		}
+		if ts.Name == "" {
+			return nil, fmt.Errorf("invalid series %v: %v", pts.Labels, ErrMissingName)
+		}
+
+		for _, s := range pts.Samples {
+			if !h.opts.KeepStaleMarkers && math.Float64bits(s.Value) == staleNaN {
+				continue
+			}
+			ts.Points = append(ts.Points, chronix.Point{
+				Timestamp: s.Timestamp,
+				Value:     s.Value,
+			})
+		}
+		if len(ts.Points) > 0 {
+			series = append(series, ts)
+		}
+	}
+	return series, nil
+}
+
+// DecodeRequest reads a snappy-compressed remote_write request of at most
+// maxSize bytes. ErrRequestTooLarge is returned for larger requests.
+func DecodeRequest(r io.Reader, maxSize int64) (*prompb.WriteRequest, error) {
+	compressed, err := ioutil.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading request: %v", err)
+	}
+	if int64(len(compressed)) > maxSize {
+		return nil, ErrRequestTooLarge
+	}
+
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing request: %v", err)
+	}
+
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("error unmarshalling request: %v", err)
+	}
+	return &req, nil
+}
+
+// EncodeRequest encodes a remote_write request the way Prometheus sends it.
+func EncodeRequest(req *prompb.WriteRequest) ([]byte, error) {
+	data, err := req.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling request: %v", err)
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+// NewRequest creates a remote_write request to the url like Prometheus does. It
+// can be used to send samples to a Handler, e.g. for testing.
+func NewRequest(url string, req *prompb.WriteRequest) (*http.Request, error) {
+	body, err := EncodeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	r.Header.Set("Content-Encoding", "snappy")
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	r.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	return r, nil
+}
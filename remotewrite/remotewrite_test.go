@@ -0,0 +1,291 @@
+package remotewrite
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// solrMock records the documents of Solr update requests.
+type solrMock struct {
+	mtx  sync.Mutex
+	docs []map[string]interface{}
+	fail bool
+}
+
+func (m *solrMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.fail {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var docs []map[string]interface{}
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&docs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.mtx.Lock()
+	m.docs = append(m.docs, docs...)
+	m.mtx.Unlock()
+}
+
+// series returns the points of the stored chunks merged by their name.
+func (m *solrMock) series(t *testing.T) map[string]*chronix.TimeSeries {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	series := map[string]*chronix.TimeSeries{}
+	for _, doc := range m.docs {
+		start, _ := doc["start"].(json.Number).Int64()
+		end, _ := doc["end"].(json.Number).Int64()
+		data, err := base64.StdEncoding.DecodeString(doc["data"].(string))
+		if err != nil {
+			t.Fatal("Error decoding data:", err)
+		}
+		points, err := chronix.DecodePoints(data, start, end, start, end)
+		if err != nil {
+			t.Fatal("Error decoding points:", err)
+		}
+		name := doc["name"].(string)
+		if ts, ok := series[name]; ok {
+			ts.Points = append(ts.Points, points...)
+			continue
+		}
+		ts := &chronix.TimeSeries{
+			Name:       name,
+			Type:       doc["type"].(string),
+			Attributes: map[string]string{},
+			Points:     points,
+		}
+		for k, v := range doc {
+			if len(k) > 2 && k[len(k)-2:] == "_s" {
+				ts.Attributes[k[:len(k)-2]] = v.(string)
+			}
+		}
+		series[name] = ts
+	}
+	return series
+}
+
+// newTestHandler starts a Handler storing into a Solr mock. The returned function
+// closes the Handler and stops the servers.
+func newTestHandler(t *testing.T, opts Options) (*solrMock, *httptest.Server, func()) {
+	solr := &solrMock{}
+	solrServer := httptest.NewServer(solr)
+	u, err := url.Parse(solrServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewHandler(chronix.NewClient(chronix.NewSolr(u)), opts)
+	server := httptest.NewServer(h)
+	return solr, server, func() {
+		server.Close()
+		if err := h.Close(); err != nil {
+			t.Error("Error closing handler:", err)
+		}
+		solrServer.Close()
+	}
+}
+
+func send(t *testing.T, server *httptest.Server, req *prompb.WriteRequest) *http.Response {
+	r, err := NewRequest(server.URL, req)
+	if err != nil {
+		t.Fatal("Error creating request:", err)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal("Error sending request:", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestHandler(t *testing.T) {
+	solr, server, stop := newTestHandler(t, Options{})
+	defer stop()
+
+	requests := []*prompb.WriteRequest{
+		{
+			Timeseries: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "up"},
+						{Name: "job", Value: "node"},
+					},
+					Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 0}},
+				},
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "stale"}},
+					Samples: []prompb.Sample{{Timestamp: 1000, Value: math.Float64frombits(staleNaN)}},
+				},
+			},
+		},
+		{
+			Timeseries: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "up"},
+						{Name: "job", Value: "node"},
+					},
+					Samples: []prompb.Sample{{Timestamp: 3000, Value: 1}},
+				},
+			},
+		},
+	}
+	for i, req := range requests {
+		if resp := send(t, server, req); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("%d. Unexpected status code %d", i, resp.StatusCode)
+		}
+	}
+
+	want := map[string]*chronix.TimeSeries{
+		"up": {
+			Name:       "up",
+			Type:       "metric",
+			Attributes: map[string]string{"job": "node"},
+			Points:     []chronix.Point{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 0}, {Timestamp: 3000, Value: 1}},
+		},
+	}
+	if got := solr.series(t); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected series. Want %v, got %v", want, got)
+	}
+}
+
+func TestHandlerStorageError(t *testing.T) {
+	solr, server, stop := newTestHandler(t, Options{})
+	defer stop()
+	solr.fail = true
+
+	resp := send(t, server, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}},
+		}},
+	})
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected requests that could not be stored to fail, got status code %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerBuffered(t *testing.T) {
+	solr, server, stop := newTestHandler(t, Options{Buffer: &chronix.BufferedWriterOptions{MaxChunkAge: time.Hour}})
+
+	for _, ts := range []int64{1000, 2000} {
+		resp := send(t, server, &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+				Samples: []prompb.Sample{{Timestamp: ts, Value: 1}},
+			}},
+		})
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("Unexpected status code %d", resp.StatusCode)
+		}
+	}
+	if len(solr.docs) != 0 {
+		t.Fatalf("Expected the samples to be buffered, got %v", solr.docs)
+	}
+	stop()
+
+	if len(solr.docs) != 1 {
+		t.Fatalf("Expected the samples to be stored in one chunk on close, got %v", solr.docs)
+	}
+	want := []chronix.Point{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 1}}
+	if got := solr.series(t)["up"].Points; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected points. Want %v, got %v", want, got)
+	}
+}
+
+func TestHandlerRenamesReservedLabelsOnElastic(t *testing.T) {
+	var bodies [][]byte
+	elasticServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal("Error reading request body:", err)
+		}
+		bodies = append(bodies, body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer elasticServer.Close()
+	elastic, err := chronix.NewElasticTestStorage(&elasticServer.URL)
+	if err != nil {
+		t.Fatal("Error creating Elastic storage:", err)
+	}
+	server := httptest.NewServer(NewHandler(chronix.NewClient(elastic), Options{}))
+	defer server.Close()
+
+	resp := send(t, server, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "up"},
+				{Name: "type", Value: "node"},
+			},
+			Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}},
+		}},
+	})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Unexpected status code %d", resp.StatusCode)
+	}
+	if len(bodies) != 1 || !bytes.Contains(bodies[0], []byte(`"exported_type":"node"`)) {
+		t.Errorf("Expected the type label to be stored as exported_type, got %q", bodies)
+	}
+}
+
+func TestHandlerRejectsInvalidRequests(t *testing.T) {
+	_, server, stop := newTestHandler(t, Options{})
+	defer stop()
+
+	resp := send(t, server, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}}}},
+	})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected series without a name to be rejected, got status code %d", resp.StatusCode)
+	}
+
+	resp, err := http.Post(server.URL, "application/x-protobuf", bytes.NewBufferString("not snappy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected malformed requests to be rejected, got status code %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET requests to be rejected, got status code %d", resp.StatusCode)
+	}
+}
+
+func TestDecodeRequestTooLarge(t *testing.T) {
+	body, err := EncodeRequest(&prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeRequest(bytes.NewReader(body), int64(len(body))); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if _, err := DecodeRequest(bytes.NewReader(body), int64(len(body)-1)); err != ErrRequestTooLarge {
+		t.Error("Expected ErrRequestTooLarge, got ", err)
+	}
+}
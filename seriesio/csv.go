@@ -0,0 +1,437 @@
+package seriesio
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+)
+
+// A Layout is the arrangement of points in a CSV file.
+type Layout int
+
+// The supported layouts.
+const (
+	// Long files have one row per point with columns for the name, type,
+	// attributes, timestamp and value, e.g.
+	//
+	//	name,type,host,timestamp,value
+	//	cpu,metric,a,1470784794000,0.5
+	Long Layout = iota
+	// Wide files have one row per timestamp with a column per series, e.g.
+	//
+	//	timestamp,cpu{host="a"},cpu{host="b"}
+	//	1470784794000,0.5,0.7
+	//
+	// Series columns are named like Prometheus series. The type is stored like an
+	// attribute unless it is the default type. Empty cells are missing points.
+	Wide
+)
+
+// CSVOptions configures a CSVReader or CSVWriter.
+type CSVOptions struct {
+	Options
+	Layout Layout
+	// Comma is the field delimiter. If it is 0, a comma is used.
+	Comma rune
+}
+
+// A CSVWriter writes series as CSV.
+type CSVWriter struct {
+	w    *csv.Writer
+	opts CSVOptions
+
+	// header are the columns of a long file once the header has been written.
+	header []string
+	// wide are the series of a wide file that have not been flushed yet.
+	wide *seriesSet
+}
+
+// NewCSVWriter creates a new CSVWriter that writes to w.
+func NewCSVWriter(w io.Writer, opts CSVOptions) *CSVWriter {
+	opts.Options = opts.Options.withDefaults()
+	cw := csv.NewWriter(w)
+	if opts.Comma != 0 {
+		cw.Comma = opts.Comma
+	}
+	return &CSVWriter{w: cw, opts: opts, wide: newSeriesSet()}
+}
+
+// Write writes the points of the series. The rows of a wide file are only
+// written by Flush, so that the points of all series written before are merged.
+// Unless Columns.Attributes is set, the header of a long file is made of the
+// attributes of the series of the first call and series with other attributes
+// are rejected later on.
+func (w *CSVWriter) Write(series ...*chronix.TimeSeries) error {
+	if w.opts.Layout == Wide {
+		for _, ts := range series {
+			w.wide.add(ts.Name, ts.Type, w.writtenAttributes(ts), ts.Points...)
+		}
+		return nil
+	}
+
+	if w.header == nil {
+		if err := w.writeLongHeader(series); err != nil {
+			return err
+		}
+	}
+	attributes := w.header[2 : len(w.header)-2]
+	for _, ts := range series {
+		if w.opts.Columns.Attributes == nil {
+			if err := checkAttributes(ts, attributes); err != nil {
+				return err
+			}
+		}
+		record := make([]string, len(w.header))
+		record[0] = ts.Name
+		record[1] = ts.Type
+		for i, a := range attributes {
+			record[2+i] = ts.Attributes[a]
+		}
+		for _, p := range ts.Points {
+			record[len(record)-2] = w.opts.Timestamps.format(p.Timestamp)
+			record[len(record)-1] = formatValue(p.Value)
+			if err := w.w.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	return w.w.Error()
+}
+
+// Flush writes the rows of a wide file and any buffered data to the underlying
+// writer. Every flush of a wide file writes a table with a header.
+func (w *CSVWriter) Flush() error {
+	if w.opts.Layout == Wide && len(w.wide.series) > 0 {
+		series := w.wide.series
+		w.wide = newSeriesSet()
+		if err := w.writeWide(series); err != nil {
+			return err
+		}
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *CSVWriter) writeLongHeader(series []*chronix.TimeSeries) error {
+	cols := w.opts.Columns
+	attributes := cols.Attributes
+	if attributes == nil {
+		seen := map[string]bool{}
+		for _, ts := range series {
+			for k := range ts.Attributes {
+				if !seen[k] {
+					seen[k] = true
+					attributes = append(attributes, k)
+				}
+			}
+		}
+		sort.Strings(attributes)
+	}
+	header := append([]string{cols.Name, cols.Type}, attributes...)
+	header = append(header, cols.Timestamp, cols.Value)
+	if err := w.w.Write(header); err != nil {
+		return err
+	}
+	w.header = header
+	return nil
+}
+
+// checkAttributes returns an error if the series has attributes other than the
+// columns, which would be lost. Series with fewer attributes leave cells empty.
+func checkAttributes(ts *chronix.TimeSeries, columns []string) error {
+	for k := range ts.Attributes {
+		found := false
+		for _, c := range columns {
+			if c == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("attribute %s of series %s is not a column", k, ts.Name)
+		}
+	}
+	return nil
+}
+
+// writtenAttributes returns the attributes of the series that are written.
+func (w *CSVWriter) writtenAttributes(ts *chronix.TimeSeries) map[string]string {
+	if w.opts.Columns.Attributes == nil {
+		return ts.Attributes
+	}
+	attributes := map[string]string{}
+	for _, k := range w.opts.Columns.Attributes {
+		if v, ok := ts.Attributes[k]; ok {
+			attributes[k] = v
+		}
+	}
+	return attributes
+}
+
+func (w *CSVWriter) writeWide(series []*chronix.TimeSeries) error {
+	header := []string{w.opts.Columns.Timestamp}
+	values := make([]map[int64]float64, len(series))
+	var timestamps []int64
+	seen := map[int64]bool{}
+	for i, ts := range series {
+		header = append(header, w.seriesColumn(ts))
+		values[i] = make(map[int64]float64, len(ts.Points))
+		for _, p := range ts.Points {
+			values[i][p.Timestamp] = p.Value
+			if !seen[p.Timestamp] {
+				seen[p.Timestamp] = true
+				timestamps = append(timestamps, p.Timestamp)
+			}
+		}
+	}
+	sort.Sort(int64s(timestamps))
+
+	if err := w.w.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(header))
+	for _, t := range timestamps {
+		record[0] = w.opts.Timestamps.format(t)
+		for i := range series {
+			record[i+1] = ""
+			if v, ok := values[i][t]; ok {
+				record[i+1] = formatValue(v)
+			}
+		}
+		if err := w.w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seriesColumn returns the column of a series in a wide file, e.g. cpu{host="a"}.
+func (w *CSVWriter) seriesColumn(ts *chronix.TimeSeries) string {
+	var labels []string
+	if ts.Type != w.opts.Type {
+		labels = append(labels, w.opts.Columns.Type+"="+strconv.Quote(ts.Type))
+	}
+	for _, k := range w.opts.Columns.attributes(ts) {
+		if v, ok := ts.Attributes[k]; ok {
+			labels = append(labels, k+"="+strconv.Quote(v))
+		}
+	}
+	if len(labels) == 0 {
+		return ts.Name
+	}
+	return ts.Name + "{" + strings.Join(labels, ",") + "}"
+}
+
+type int64s []int64
+
+func (s int64s) Len() int           { return len(s) }
+func (s int64s) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// A CSVReader reads series from CSV.
+type CSVReader struct {
+	r    *csv.Reader
+	opts CSVOptions
+}
+
+// NewCSVReader creates a new CSVReader that reads from r.
+func NewCSVReader(r io.Reader, opts CSVOptions) *CSVReader {
+	opts.Options = opts.Options.withDefaults()
+	cr := csv.NewReader(r)
+	if opts.Comma != 0 {
+		cr.Comma = opts.Comma
+	}
+	return &CSVReader{r: cr, opts: opts}
+}
+
+// ReadAll reads all records and returns the series with their points sorted by
+// timestamp. The first record has to be the header.
+func (r *CSVReader) ReadAll() ([]*chronix.TimeSeries, error) {
+	header, err := r.r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	set := newSeriesSet()
+	if r.opts.Layout == Wide {
+		err = r.readWide(header, set)
+	} else {
+		err = r.readLong(header, set)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return set.sorted(), nil
+}
+
+func (r *CSVReader) readLong(header []string, set *seriesSet) error {
+	cols := r.opts.Columns
+	name, typ, timestamp, value := -1, -1, -1, -1
+	attributes := map[int]string{}
+	for i, h := range header {
+		switch {
+		case h == cols.Name:
+			name = i
+		case h == cols.Type:
+			typ = i
+		case h == cols.Timestamp:
+			timestamp = i
+		case h == cols.Value:
+			value = i
+		case cols.isAttribute(h):
+			attributes[i] = h
+		}
+	}
+	for _, c := range []struct {
+		name  string
+		index int
+	}{{cols.Name, name}, {cols.Timestamp, timestamp}, {cols.Value, value}} {
+		if c.index < 0 {
+			return &ParseError{Record: 1, Err: fmt.Errorf("missing column %s", c.name)}
+		}
+	}
+
+	for n := 2; ; n++ {
+		record, err := r.r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p, err := r.parsePoint(record[timestamp], record[value])
+		if err != nil {
+			return &ParseError{Record: n, Err: err}
+		}
+		if record[name] == "" {
+			return &ParseError{Record: n, Err: errors.New("empty name")}
+		}
+		t := r.opts.Type
+		if typ >= 0 && record[typ] != "" {
+			t = record[typ]
+		}
+		attrs := make(map[string]string, len(attributes))
+		for i, a := range attributes {
+			if record[i] != "" {
+				attrs[a] = record[i]
+			}
+		}
+		set.add(record[name], t, attrs, p)
+	}
+}
+
+func (r *CSVReader) readWide(header []string, set *seriesSet) error {
+	timestamp := -1
+	columns := make([]*chronix.TimeSeries, len(header))
+	for i, h := range header {
+		if h == r.opts.Columns.Timestamp {
+			timestamp = i
+			continue
+		}
+		name, labels, err := parseSeriesColumn(h)
+		if err != nil {
+			return &ParseError{Record: 1, Err: err}
+		}
+		typ := r.opts.Type
+		attributes := map[string]string{}
+		for k, v := range labels {
+			switch {
+			case k == r.opts.Columns.Type:
+				typ = v
+			case r.opts.Columns.isAttribute(k):
+				attributes[k] = v
+			}
+		}
+		columns[i] = set.add(name, typ, attributes)
+	}
+	if timestamp < 0 {
+		return &ParseError{Record: 1, Err: fmt.Errorf("missing column %s", r.opts.Columns.Timestamp)}
+	}
+
+	for n := 2; ; n++ {
+		record, err := r.r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for i, ts := range columns {
+			if ts == nil || record[i] == "" {
+				continue
+			}
+			p, err := r.parsePoint(record[timestamp], record[i])
+			if err != nil {
+				return &ParseError{Record: n, Err: err}
+			}
+			ts.Points = append(ts.Points, p)
+		}
+	}
+}
+
+func (r *CSVReader) parsePoint(timestamp, value string) (chronix.Point, error) {
+	t, err := r.opts.Timestamps.parse(timestamp)
+	if err != nil {
+		return chronix.Point{}, err
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return chronix.Point{}, fmt.Errorf("invalid value '%s'", value)
+	}
+	return chronix.Point{Timestamp: t, Value: v}, nil
+}
+
+// parseSeriesColumn parses a series column like cpu{host="a",region="eu"}.
+func parseSeriesColumn(s string) (string, map[string]string, error) {
+	labels := map[string]string{}
+	i := strings.IndexByte(s, '{')
+	if i < 0 {
+		if s == "" {
+			return "", nil, errors.New("empty series column")
+		}
+		return s, labels, nil
+	}
+	name, rest := s[:i], s[i+1:]
+	if name == "" || !strings.HasSuffix(rest, "}") {
+		return "", nil, fmt.Errorf("invalid series column %s", s)
+	}
+	rest = strings.TrimSpace(rest[:len(rest)-1])
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 || eq+1 >= len(rest) || rest[eq+1] != '"' {
+			return "", nil, fmt.Errorf("invalid series column %s", s)
+		}
+		key := strings.TrimSpace(rest[:eq])
+		// Find the closing quote of the value.
+		end := eq + 2
+		for end < len(rest) && rest[end] != '"' {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			return "", nil, fmt.Errorf("invalid series column %s", s)
+		}
+		value, err := strconv.Unquote(rest[eq+1 : end+1])
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid series column %s", s)
+		}
+		labels[key] = value
+		rest = strings.TrimSpace(rest[end+1:])
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if rest != "" {
+			return "", nil, fmt.Errorf("invalid series column %s", s)
+		}
+	}
+	return name, labels, nil
+}
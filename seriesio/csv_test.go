@@ -0,0 +1,146 @@
+package seriesio
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+)
+
+var testSeries = []*chronix.TimeSeries{
+	{
+		Name:       "cpu",
+		Type:       "metric",
+		Attributes: map[string]string{"host": "a"},
+		Points:     []chronix.Point{{Timestamp: 1000, Value: 0.5}, {Timestamp: 2000, Value: 0.75}},
+	},
+	{
+		Name:       "cpu",
+		Type:       "metric",
+		Attributes: map[string]string{"host": "b, \"c\"", "region": "eu"},
+		Points:     []chronix.Point{{Timestamp: 2000, Value: math.Inf(1)}},
+	},
+	{
+		Name:       "log",
+		Type:       "event",
+		Attributes: map[string]string{},
+		Points:     []chronix.Point{{Timestamp: 1500, Value: 3}},
+	},
+}
+
+func TestCSVLong(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewCSVWriter(&buf, CSVOptions{Options: Options{Timestamps: EpochSeconds}})
+	if err := w.Write(testSeries...); err != nil {
+		t.Fatal("Error writing series:", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+
+	want := `name,type,host,region,timestamp,value
+cpu,metric,a,,1,0.5
+cpu,metric,a,,2,0.75
+cpu,metric,"b, ""c""",eu,2,+Inf
+log,event,,,1.5,3
+`
+	if buf.String() != want {
+		t.Fatalf("Unexpected CSV. Want:\n%s\nGot:\n%s", want, buf.String())
+	}
+
+	got, err := NewCSVReader(&buf, CSVOptions{Options: Options{Timestamps: EpochSeconds}}).ReadAll()
+	if err != nil {
+		t.Fatal("Error reading series:", err)
+	}
+	if !reflect.DeepEqual(got, testSeries) {
+		t.Fatalf("Unexpected series. Want %v, got %v", testSeries, got)
+	}
+
+	if err := w.Write(&chronix.TimeSeries{Name: "mem", Attributes: map[string]string{"dc": "1"}}); err == nil {
+		t.Fatal("Expected an error for an attribute that is not a column")
+	}
+}
+
+func TestCSVLongColumns(t *testing.T) {
+	input := "time;metric;host;dc;v\n2016-08-09T23:19:55Z;cpu;a;x;2\n2016-08-09T23:19:54Z;cpu;a;y;1\n"
+	opts := CSVOptions{
+		Options: Options{
+			Columns:    Columns{Name: "metric", Timestamp: "time", Value: "v", Attributes: []string{"host"}},
+			Timestamps: RFC3339,
+			Type:       "gauge",
+		},
+		Comma: ';',
+	}
+	got, err := NewCSVReader(strings.NewReader(input), opts).ReadAll()
+	if err != nil {
+		t.Fatal("Error reading series:", err)
+	}
+	want := []*chronix.TimeSeries{{
+		Name:       "cpu",
+		Type:       "gauge",
+		Attributes: map[string]string{"host": "a"},
+		Points:     []chronix.Point{{Timestamp: 1470784794000, Value: 1}, {Timestamp: 1470784795000, Value: 2}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected series. Want %v, got %v", want, got)
+	}
+}
+
+func TestCSVWide(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewCSVWriter(&buf, CSVOptions{Layout: Wide})
+	// Chunks of the same series are merged.
+	for _, ts := range testSeries {
+		for _, p := range ts.Points {
+			if err := w.Write(&chronix.TimeSeries{Name: ts.Name, Type: ts.Type, Attributes: ts.Attributes, Points: []chronix.Point{p}}); err != nil {
+				t.Fatal("Error writing series:", err)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+
+	want := `timestamp,"cpu{host=""a""}","cpu{host=""b, \""c\"""",region=""eu""}","log{type=""event""}"
+1000,0.5,,
+1500,,,3
+2000,0.75,+Inf,
+`
+	if buf.String() != want {
+		t.Fatalf("Unexpected CSV. Want:\n%s\nGot:\n%s", want, buf.String())
+	}
+
+	got, err := NewCSVReader(&buf, CSVOptions{Layout: Wide}).ReadAll()
+	if err != nil {
+		t.Fatal("Error reading series:", err)
+	}
+	if !reflect.DeepEqual(got, testSeries) {
+		t.Fatalf("Unexpected series. Want %v, got %v", testSeries, got)
+	}
+}
+
+func TestCSVReaderErrors(t *testing.T) {
+	tests := []struct {
+		layout Layout
+		input  string
+		record int
+	}{
+		{layout: Long, input: "name,value\ncpu,1\n", record: 1},
+		{layout: Long, input: "name,timestamp,value\ncpu,1,1\n,2,2\n", record: 3},
+		{layout: Long, input: "name,timestamp,value\ncpu,x,1\n", record: 2},
+		{layout: Long, input: "name,timestamp,value\ncpu,1e19,1\n", record: 2},
+		{layout: Long, input: "name,timestamp,value\ncpu,1,x\n", record: 2},
+		{layout: Wide, input: "time,cpu\n1,1\n", record: 1},
+		{layout: Wide, input: "timestamp,cpu{host=a}\n1,1\n", record: 1},
+		{layout: Wide, input: "timestamp,cpu\n1,1\n2,x\n", record: 3},
+	}
+	for i, test := range tests {
+		_, err := NewCSVReader(strings.NewReader(test.input), CSVOptions{Layout: test.layout}).ReadAll()
+		if e, ok := err.(*ParseError); !ok || e.Record != test.record {
+			t.Errorf("%d. Expected a *ParseError for record %d, got %v", i, test.record, err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package seriesio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+)
+
+// A JSONLinesWriter writes series as JSON Lines with one object per point, e.g.
+//
+//	{"name":"cpu","type":"metric","host":"a","timestamp":1470784794000,"value":0.5}
+//
+// Timestamps are numbers unless they are written as RFC3339. NaN and infinite
+// values are written as the strings "NaN", "+Inf" and "-Inf".
+type JSONLinesWriter struct {
+	w    *bufio.Writer
+	opts Options
+	buf  bytes.Buffer
+}
+
+// NewJSONLinesWriter creates a new JSONLinesWriter that writes to w.
+func NewJSONLinesWriter(w io.Writer, opts Options) *JSONLinesWriter {
+	return &JSONLinesWriter{w: bufio.NewWriter(w), opts: opts.withDefaults()}
+}
+
+// Write writes the points of the series.
+func (w *JSONLinesWriter) Write(series ...*chronix.TimeSeries) error {
+	cols := w.opts.Columns
+	for _, ts := range series {
+		// The part of the object shared by all points.
+		w.buf.Reset()
+		w.buf.WriteByte('{')
+		writeJSONField(&w.buf, cols.Name, ts.Name)
+		w.buf.WriteByte(',')
+		writeJSONField(&w.buf, cols.Type, ts.Type)
+		for _, k := range cols.attributes(ts) {
+			if v, ok := ts.Attributes[k]; ok {
+				w.buf.WriteByte(',')
+				writeJSONField(&w.buf, k, v)
+			}
+		}
+		w.buf.WriteByte(',')
+		prefix := w.buf.Len()
+
+		for _, p := range ts.Points {
+			w.buf.Truncate(prefix)
+			if w.opts.Timestamps == RFC3339 {
+				writeJSONField(&w.buf, cols.Timestamp, w.opts.Timestamps.format(p.Timestamp))
+			} else {
+				writeJSONField(&w.buf, cols.Timestamp, json.Number(w.opts.Timestamps.format(p.Timestamp)))
+			}
+			w.buf.WriteByte(',')
+			if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+				writeJSONField(&w.buf, cols.Value, formatSpecialValue(p.Value))
+			} else {
+				writeJSONField(&w.buf, cols.Value, p.Value)
+			}
+			w.buf.WriteString("}\n")
+			if _, err := w.w.Write(w.buf.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (w *JSONLinesWriter) Flush() error {
+	return w.w.Flush()
+}
+
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}) {
+	// Strings, numbers and floats other than NaN and Inf always marshal.
+	k, _ := json.Marshal(key)
+	v, _ := json.Marshal(value)
+	buf.Write(k)
+	buf.WriteByte(':')
+	buf.Write(v)
+}
+
+func formatSpecialValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return "NaN"
+}
+
+// A JSONLinesReader reads series from JSON Lines with one object per point.
+type JSONLinesReader struct {
+	dec  *json.Decoder
+	opts Options
+}
+
+// NewJSONLinesReader creates a new JSONLinesReader that reads from r.
+func NewJSONLinesReader(r io.Reader, opts Options) *JSONLinesReader {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &JSONLinesReader{dec: dec, opts: opts.withDefaults()}
+}
+
+// ReadAll reads all objects and returns the series with their points sorted by
+// timestamp. Attributes have to be strings, numbers or booleans.
+func (r *JSONLinesReader) ReadAll() ([]*chronix.TimeSeries, error) {
+	set := newSeriesSet()
+	for n := 1; ; n++ {
+		var obj map[string]interface{}
+		if err := r.dec.Decode(&obj); err == io.EOF {
+			return set.sorted(), nil
+		} else if err != nil {
+			return nil, &ParseError{Record: n, Err: err}
+		}
+		if err := r.add(set, obj); err != nil {
+			return nil, &ParseError{Record: n, Err: err}
+		}
+	}
+}
+
+func (r *JSONLinesReader) add(set *seriesSet, obj map[string]interface{}) error {
+	cols := r.opts.Columns
+	name, ok := obj[cols.Name].(string)
+	if !ok || name == "" {
+		return fmt.Errorf("missing name %s", cols.Name)
+	}
+	typ := r.opts.Type
+	if v, ok := obj[cols.Type]; ok {
+		if typ, ok = v.(string); !ok {
+			return fmt.Errorf("invalid type %v", v)
+		}
+	}
+
+	var p chronix.Point
+	switch v := obj[cols.Timestamp].(type) {
+	case json.Number:
+		if r.opts.Timestamps == RFC3339 {
+			return fmt.Errorf("invalid timestamp %v", v)
+		}
+		t, err := r.opts.Timestamps.parse(v.String())
+		if err != nil {
+			return err
+		}
+		p.Timestamp = t
+	case string:
+		t, err := r.opts.Timestamps.parse(v)
+		if err != nil {
+			return err
+		}
+		p.Timestamp = t
+	default:
+		return fmt.Errorf("missing timestamp %s", cols.Timestamp)
+	}
+	switch v := obj[cols.Value].(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return fmt.Errorf("invalid value %v", v)
+		}
+		p.Value = f
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value '%s'", v)
+		}
+		p.Value = f
+	default:
+		return fmt.Errorf("missing value %s", cols.Value)
+	}
+
+	attributes := map[string]string{}
+	for k, v := range obj {
+		if !cols.isAttribute(k) {
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			attributes[k] = v
+		case json.Number:
+			attributes[k] = v.String()
+		case bool:
+			attributes[k] = strconv.FormatBool(v)
+		case nil:
+		default:
+			return fmt.Errorf("attribute %s is not a string, number or boolean", k)
+		}
+	}
+	set.add(name, typ, attributes, p)
+	return nil
+}
@@ -0,0 +1,90 @@
+package seriesio
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+)
+
+func TestJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewJSONLinesWriter(&buf, Options{Timestamps: RFC3339})
+	if err := w.Write(testSeries...); err != nil {
+		t.Fatal("Error writing series:", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+
+	want := `{"name":"cpu","type":"metric","host":"a","timestamp":"1970-01-01T00:00:01Z","value":0.5}
+{"name":"cpu","type":"metric","host":"a","timestamp":"1970-01-01T00:00:02Z","value":0.75}
+{"name":"cpu","type":"metric","host":"b, \"c\"","region":"eu","timestamp":"1970-01-01T00:00:02Z","value":"+Inf"}
+{"name":"log","type":"event","timestamp":"1970-01-01T00:00:01.5Z","value":3}
+`
+	if buf.String() != want {
+		t.Fatalf("Unexpected JSON Lines. Want:\n%s\nGot:\n%s", want, buf.String())
+	}
+
+	got, err := NewJSONLinesReader(&buf, Options{Timestamps: RFC3339}).ReadAll()
+	if err != nil {
+		t.Fatal("Error reading series:", err)
+	}
+	if !reflect.DeepEqual(got, testSeries) {
+		t.Fatalf("Unexpected series. Want %v, got %v", testSeries, got)
+	}
+}
+
+func TestJSONLinesColumns(t *testing.T) {
+	input := `{"metric":"cpu","ts":1470784795000000000,"v":2,"host":"a","port":8080,"ok":true}
+{"metric":"cpu","ts":1470784794000000000,"v":"1","host":"a","port":8080,"ok":true}
+`
+	opts := Options{
+		Columns:    Columns{Name: "metric", Timestamp: "ts", Value: "v"},
+		Timestamps: EpochNanos,
+	}
+	got, err := NewJSONLinesReader(strings.NewReader(input), opts).ReadAll()
+	if err != nil {
+		t.Fatal("Error reading series:", err)
+	}
+	want := []*chronix.TimeSeries{{
+		Name:       "cpu",
+		Type:       "metric",
+		Attributes: map[string]string{"host": "a", "port": "8080", "ok": "true"},
+		Points:     []chronix.Point{{Timestamp: 1470784794000, Value: 1}, {Timestamp: 1470784795000, Value: 2}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected series. Want %v, got %v", want, got)
+	}
+
+	var buf bytes.Buffer
+	w := NewJSONLinesWriter(&buf, Options{Columns: Columns{Attributes: []string{"host", "dc"}}})
+	w.Write(got...)
+	w.Flush()
+	wantOutput := `{"name":"cpu","type":"metric","host":"a","timestamp":1470784794000,"value":1}
+{"name":"cpu","type":"metric","host":"a","timestamp":1470784795000,"value":2}
+`
+	if buf.String() != wantOutput {
+		t.Fatalf("Unexpected JSON Lines. Want:\n%s\nGot:\n%s", wantOutput, buf.String())
+	}
+}
+
+func TestJSONLinesReaderErrors(t *testing.T) {
+	valid := `{"name":"cpu","timestamp":1,"value":1}` + "\n"
+	for _, line := range []string{
+		`{"timestamp":1,"value":1}`,
+		`{"name":"cpu","value":1}`,
+		`{"name":"cpu","timestamp":1}`,
+		`{"name":"cpu","timestamp":"x","value":1}`,
+		`{"name":"cpu","timestamp":1,"value":"x"}`,
+		`{"name":"cpu","timestamp":1,"value":1,"host":{}}`,
+		`{"name":"cpu",`,
+	} {
+		_, err := NewJSONLinesReader(strings.NewReader(valid+line), Options{}).ReadAll()
+		if e, ok := err.(*ParseError); !ok || e.Record != 2 {
+			t.Errorf("Expected a *ParseError for record 2 %s, got %v", line, err)
+		}
+	}
+}
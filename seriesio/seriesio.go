@@ -0,0 +1,227 @@
+// Package seriesio reads and writes time series as CSV and JSON Lines, e.g. to
+// import data with Client.Store or to export the series of a query.
+package seriesio
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChronixDB/chronix.go/chronix"
+)
+
+// DefaultType is the default type of series without a type.
+const DefaultType = "metric"
+
+// A TimestampFormat is the representation of timestamps.
+type TimestampFormat int
+
+// The supported timestamp formats.
+const (
+	// EpochMillis are milliseconds since the epoch, like Chronix stores them.
+	EpochMillis TimestampFormat = iota
+	// EpochSeconds are seconds since the epoch, possibly with a fraction.
+	EpochSeconds
+	// EpochNanos are nanoseconds since the epoch.
+	EpochNanos
+	// RFC3339 are RFC 3339 times such as "2016-08-09T23:19:54.123Z". They are
+	// written in UTC.
+	RFC3339
+)
+
+// parse parses a timestamp into milliseconds since the epoch.
+func (f TimestampFormat) parse(s string) (int64, error) {
+	switch f {
+	case EpochMillis:
+		return parseEpoch(s, 1, 1)
+	case EpochSeconds:
+		return parseEpoch(s, 1000, 1)
+	case EpochNanos:
+		return parseEpoch(s, 1, int64(time.Millisecond))
+	case RFC3339:
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp '%s'", s)
+		}
+		return t.Unix()*1000 + int64(t.Nanosecond())/int64(time.Millisecond), nil
+	}
+	return 0, fmt.Errorf("unknown timestamp format %d", f)
+}
+
+// parseEpoch parses a number and converts it to milliseconds by multiplying
+// with mul and dividing by div. Timestamps whose milliseconds do not fit into
+// an int64 are rejected.
+func parseEpoch(s string, mul, div int64) (int64, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if i > math.MaxInt64/mul || i < math.MinInt64/mul {
+			return 0, fmt.Errorf("timestamp '%s' out of range", s)
+		}
+		return i * mul / div, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid timestamp '%s'", s)
+	}
+	ms := math.Floor(v*float64(mul)/float64(div) + 0.5)
+	if ms >= math.MaxInt64 || ms < math.MinInt64 {
+		return 0, fmt.Errorf("timestamp '%s' out of range", s)
+	}
+	return int64(ms), nil
+}
+
+// format formats a timestamp in milliseconds since the epoch.
+func (f TimestampFormat) format(ms int64) string {
+	switch f {
+	case EpochSeconds:
+		if ms%1000 == 0 {
+			return strconv.FormatInt(ms/1000, 10)
+		}
+		return strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64)
+	case EpochNanos:
+		return strconv.FormatInt(ms*int64(time.Millisecond), 10)
+	case RFC3339:
+		return time.Unix(ms/1000, ms%1000*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+// Columns maps the parts of a point to columns of CSV files or keys of JSON objects.
+// Empty names are replaced by the defaults "name", "type", "timestamp" and "value".
+type Columns struct {
+	Name      string
+	Type      string
+	Timestamp string
+	Value     string
+	// Attributes are the columns of the attributes. If it is nil, all attributes
+	// are written and all other columns are read as attributes.
+	Attributes []string
+}
+
+func (c Columns) withDefaults() Columns {
+	if c.Name == "" {
+		c.Name = "name"
+	}
+	if c.Type == "" {
+		c.Type = "type"
+	}
+	if c.Timestamp == "" {
+		c.Timestamp = "timestamp"
+	}
+	if c.Value == "" {
+		c.Value = "value"
+	}
+	return c
+}
+
+// isAttribute reports whether the column is read as an attribute.
+func (c Columns) isAttribute(column string) bool {
+	if c.Attributes == nil {
+		return column != c.Name && column != c.Type && column != c.Timestamp && column != c.Value
+	}
+	for _, a := range c.Attributes {
+		if a == column {
+			return true
+		}
+	}
+	return false
+}
+
+// attributes returns the attributes of a series that are written.
+func (c Columns) attributes(ts *chronix.TimeSeries) []string {
+	if c.Attributes != nil {
+		return c.Attributes
+	}
+	names := make([]string, 0, len(ts.Attributes))
+	for k := range ts.Attributes {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Options configures the readers and writers.
+type Options struct {
+	Columns Columns
+	// Timestamps is the format of the timestamps.
+	Timestamps TimestampFormat
+	// Type is the type of series read without a type. If it is empty, DefaultType is used.
+	Type string
+}
+
+func (o Options) withDefaults() Options {
+	o.Columns = o.Columns.withDefaults()
+	if o.Type == "" {
+		o.Type = DefaultType
+	}
+	return o
+}
+
+// A ParseError is returned for invalid records.
+type ParseError struct {
+	// Record is the number of the invalid record, counting from 1. The header
+	// of a CSV file is a record as well.
+	Record int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("error parsing record %d: %v", e.Record, e.Err)
+}
+
+// seriesSet groups points into series by their name, type and attributes.
+type seriesSet struct {
+	series []*chronix.TimeSeries
+	byKey  map[string]*chronix.TimeSeries
+}
+
+func newSeriesSet() *seriesSet {
+	return &seriesSet{byKey: map[string]*chronix.TimeSeries{}}
+}
+
+// add adds points to the series. The attributes are not copied.
+func (s *seriesSet) add(name, typ string, attributes map[string]string, points ...chronix.Point) *chronix.TimeSeries {
+	key := seriesKey(name, typ, attributes)
+	ts, ok := s.byKey[key]
+	if !ok {
+		ts = &chronix.TimeSeries{Name: name, Type: typ, Attributes: attributes}
+		s.byKey[key] = ts
+		s.series = append(s.series, ts)
+	}
+	ts.Points = append(ts.Points, points...)
+	return ts
+}
+
+// sorted returns the series with their points sorted by timestamp.
+func (s *seriesSet) sorted() []*chronix.TimeSeries {
+	for _, ts := range s.series {
+		sort.Stable(byTimestamp(ts.Points))
+	}
+	return s.series
+}
+
+type byTimestamp []chronix.Point
+
+func (p byTimestamp) Len() int           { return len(p) }
+func (p byTimestamp) Less(i, j int) bool { return p[i].Timestamp < p[j].Timestamp }
+func (p byTimestamp) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// seriesKey returns a string identifying a series by its name, type and attributes.
+func seriesKey(name, typ string, attributes map[string]string) string {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{name, typ}
+	for _, k := range keys {
+		parts = append(parts, k, attributes[k])
+	}
+	return strings.Join(parts, "\xff")
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,52 @@
+package seriesio
+
+import "testing"
+
+func TestTimestampFormats(t *testing.T) {
+	tests := []struct {
+		format TimestampFormat
+		input  string
+		want   int64
+		output string
+	}{
+		{format: EpochMillis, input: "1470784794123", want: 1470784794123, output: "1470784794123"},
+		{format: EpochSeconds, input: "1470784794", want: 1470784794000, output: "1470784794"},
+		{format: EpochSeconds, input: "1470784794.123", want: 1470784794123, output: "1470784794.123"},
+		{format: EpochNanos, input: "1470784794123456789", want: 1470784794123, output: "1470784794123000000"},
+		{format: RFC3339, input: "2016-08-09T23:19:54.123Z", want: 1470784794123, output: "2016-08-09T23:19:54.123Z"},
+		{format: RFC3339, input: "2016-08-10T01:19:54+02:00", want: 1470784794000, output: "2016-08-09T23:19:54Z"},
+	}
+	for _, test := range tests {
+		got, err := test.format.parse(test.input)
+		if err != nil {
+			t.Fatalf("Error parsing %s: %v", test.input, err)
+		}
+		if got != test.want {
+			t.Errorf("Unexpected timestamp of %s. Want %d, got %d", test.input, test.want, got)
+		}
+		if output := test.format.format(got); output != test.output {
+			t.Errorf("Unexpected formatted timestamp. Want %s, got %s", test.output, output)
+		}
+	}
+
+	for _, f := range []TimestampFormat{EpochMillis, EpochSeconds, EpochNanos, RFC3339} {
+		if _, err := f.parse("yesterday"); err == nil {
+			t.Errorf("Expected an error for format %d", f)
+		}
+	}
+
+	outOfRange := []struct {
+		format TimestampFormat
+		input  string
+	}{
+		{format: EpochSeconds, input: "9223372036854776"},
+		{format: EpochSeconds, input: "-9223372036854776"},
+		{format: EpochSeconds, input: "1e17"},
+		{format: EpochMillis, input: "1e19"},
+	}
+	for _, test := range outOfRange {
+		if got, err := test.format.parse(test.input); err == nil {
+			t.Errorf("Expected an error for %s, got %d", test.input, got)
+		}
+	}
+}